@@ -0,0 +1,150 @@
+package titan_client
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	files "github.com/ipfs/go-ipfs-files"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"io"
+	"os"
+	gopath "path"
+	"path/filepath"
+)
+
+// ArchiveFormat writes a downloaded file or directory as one archive stream,
+// select it per download with WithArchiveFormatOption.
+// implement it to add a format, see NewCompressedTarFormat for tar based ones
+type ArchiveFormat interface {
+	// Extension the suffix of the archive file name, eg: ".tar.gz"
+	Extension() string
+	// WriteArchive write node with the root name to w, w is not closed
+	WriteArchive(w io.Writer, node files.Node, name string) error
+}
+
+// NewTarFormat plain tar, ".tar"
+func NewTarFormat() ArchiveFormat {
+	return NewCompressedTarFormat(".tar", nil)
+}
+
+// NewTarGzFormat gzip compressed tar, ".tar.gz". level eg: gzip.DefaultCompression
+func NewTarGzFormat(level int) ArchiveFormat {
+	return NewCompressedTarFormat(".tar.gz", func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(w, level)
+	})
+}
+
+// NewTarZstdFormat zstd compressed tar, ".tar.zst". level is a zstd level from 1 to 22,
+// 0 is the default level
+func NewTarZstdFormat(level int) ArchiveFormat {
+	return NewCompressedTarFormat(".tar.zst", func(w io.Writer) (io.WriteCloser, error) {
+		encoderLevel := zstd.SpeedDefault
+		if level > 0 {
+			encoderLevel = zstd.EncoderLevelFromZstd(level)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(encoderLevel))
+	})
+}
+
+// NewTarXzFormat xz compressed tar, ".tar.xz"
+func NewTarXzFormat() ArchiveFormat {
+	return NewCompressedTarFormat(".tar.xz", func(w io.Writer) (io.WriteCloser, error) {
+		return xz.NewWriter(w)
+	})
+}
+
+// NewCompressedTarFormat tar compressed by the writers of newCompressor,
+// nil for no compression. extension eg: ".tar.bz2"
+func NewCompressedTarFormat(extension string, newCompressor func(w io.Writer) (io.WriteCloser, error)) ArchiveFormat {
+	return &tarFormat{extension: extension, newCompressor: newCompressor}
+}
+
+type tarFormat struct {
+	extension     string
+	newCompressor func(w io.Writer) (io.WriteCloser, error)
+}
+
+func (tf *tarFormat) Extension() string {
+	return tf.extension
+}
+
+func (tf *tarFormat) WriteArchive(w io.Writer, node files.Node, name string) error {
+	cw := io.WriteCloser(&identityWriteCloser{w})
+	if tf.newCompressor != nil {
+		var err error
+		if cw, err = tf.newCompressor(w); err != nil {
+			return err
+		}
+	}
+	if err := writeTar(cw, node, name); err != nil {
+		return err
+	}
+	return cw.Close()
+}
+
+// NewZipFormat zip, ".zip". level is a flate level, eg: flate.DefaultCompression,
+// flate.NoCompression stores the files
+func NewZipFormat(level int) ArchiveFormat {
+	return &zipFormat{level: level}
+}
+
+type zipFormat struct {
+	level int
+}
+
+func (zf *zipFormat) Extension() string {
+	return ".zip"
+}
+
+func (zf *zipFormat) WriteArchive(w io.Writer, node files.Node, name string) error {
+	zw := zip.NewWriter(w)
+	zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, zf.level)
+	})
+	method := zip.Deflate
+	if zf.level == flate.NoCompression {
+		method = zip.Store
+	}
+
+	err := files.Walk(node, func(fpath string, nd files.Node) error {
+		meta := nodeMeta(nd)
+		header := &zip.FileHeader{
+			Name:     gopath.Join(name, filepath.ToSlash(fpath)),
+			Method:   method,
+			Modified: meta.modTime(),
+		}
+		switch f := nd.(type) {
+		case files.Directory:
+			header.Name += "/"
+			header.Method = zip.Store
+			header.SetMode(os.ModeDir | meta.fileMode(defaultDirMode))
+			_, err := zw.CreateHeader(header)
+			return err
+		case *files.Symlink:
+			header.Method = zip.Store
+			header.SetMode(os.ModeSymlink | 0777)
+			fw, err := zw.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(fw, f.Target)
+			return err
+		case files.File:
+			header.SetMode(meta.fileMode(defaultFileMode))
+			fw, err := zw.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(fw, f)
+			return err
+		default:
+			return fmt.Errorf("unsupported node type %T", nd)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
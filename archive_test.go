@@ -0,0 +1,134 @@
+package titan_client
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readTar the regular files of a tar by name
+func readTar(t *testing.T, r io.Reader) map[string]string {
+	out := make(map[string]string)
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Typeflag == tar.TypeReg {
+			b, _ := io.ReadAll(tr)
+			out[h.Name] = string(b)
+		}
+	}
+}
+
+func readZip(t *testing.T, data []byte) map[string]string {
+	out := make(map[string]string)
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(rc)
+		_ = rc.Close()
+		out[f.Name] = string(b)
+	}
+	return out
+}
+
+func TestArchiveFormats(t *testing.T) {
+	decoders := map[string]func(data []byte) map[string]string{
+		".tar": func(data []byte) map[string]string {
+			return readTar(t, bytes.NewReader(data))
+		},
+		".tar.gz": func(data []byte) map[string]string {
+			r, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return readTar(t, r)
+		},
+		".tar.zst": func(data []byte) map[string]string {
+			r, err := zstd.NewReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			return readTar(t, r)
+		},
+		".tar.xz": func(data []byte) map[string]string {
+			r, err := xz.NewReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return readTar(t, r)
+		},
+		".zip": func(data []byte) map[string]string {
+			return readZip(t, data)
+		},
+	}
+	formats := []ArchiveFormat{
+		NewTarFormat(),
+		NewTarGzFormat(gzip.BestSpeed),
+		NewTarZstdFormat(0),
+		NewTarXzFormat(),
+		NewZipFormat(flate.DefaultCompression),
+	}
+	for _, format := range formats {
+		reader, err := fileArchive(testDirectory(), "root", false, gzip.NoCompression, format)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		got := decoders[format.Extension()](data)
+		if got["root/a.txt"] != "titan a" || got["root/sub/b.txt"] != "titan b" || len(got) != 2 {
+			t.Errorf("%s: unexpected files %v", format.Extension(), got)
+		}
+	}
+}
+
+func TestWriterFormat(t *testing.T) {
+	dir := t.TempDir()
+	format := NewZipFormat(flate.BestSpeed)
+	reader, err := fileArchive(testDirectory(), "root", false, gzip.NoCompression, format)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ow := Writer{Format: format}
+	if err = ow.Write(reader, filepath.Join(dir, "data")); err != nil {
+		t.Error(err)
+		return
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "data.zip"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got := readZip(t, data); got["root/sub/b.txt"] != "titan b" {
+		t.Errorf("unexpected files %v", got)
+	}
+}
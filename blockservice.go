@@ -18,9 +18,10 @@ type blockService struct {
 }
 
 // newBlockService creates a BlockService with given datastore instance.
-func newBlockService(customGatewayAddr, locatorAddr string) *blockService {
+func newBlockService(customGatewayAddr, locatorAddr string, option ...util.FetcherOption) *blockService {
+	option = append([]util.FetcherOption{util.WithLocatorAddressOption(locatorAddr)}, option...)
 	return &blockService{
-		ds:                util.NewFetcher(util.WithLocatorAddressOption(locatorAddr)),
+		ds:                util.NewFetcher(option...),
 		customGatewayAddr: customGatewayAddr,
 		locatorAddr:       locatorAddr,
 	}
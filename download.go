@@ -8,12 +8,18 @@ import (
 	"fmt"
 	"github.com/ipfs/go-cid"
 	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
 	logging "github.com/ipfs/go-log/v2"
 	md "github.com/ipfs/go-merkledag"
-	unixFile "github.com/ipfs/go-unixfs/file"
+	"github.com/timtide/titan-client/util"
+	"go.opencensus.io/stats"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	gopath "path"
 	"strings"
+	"sync"
+	"time"
 )
 
 // need scientific Internet access
@@ -22,9 +28,14 @@ const RouteProtocol = "/api/v0/block/get"
 
 var logger = logging.Logger("go-titan-client")
 
+// ErrIsDirectory the cid is a directory and can only be downloaded as an archive or extracted
+var ErrIsDirectory = errors.New("cid is a directory, not a file")
+
 // defaultBufSize is the buffer size for gets. for now, 1MiB, which is ~4 blocks.
 const defaultBufSize = 1048576
 
+// Downloader downloads a cid.
+// the Downloader of NewDownloader also implements OptionDownloader, SinkDownloader and Lister
 type Downloader interface {
 	// GetReader returns a read pipe
 	// note: remember to close after using
@@ -37,6 +48,39 @@ type Downloader interface {
 	Download(ctx context.Context, cid cid.Cid, archive bool, compressLevel int, outPath string) error
 }
 
+// OptionDownloader is GetReader and Download with DownloadOption, eg: NewDownloader().(OptionDownloader)
+type OptionDownloader interface {
+	// GetReaderWithOptions GetReader configured by option
+	GetReaderWithOptions(ctx context.Context, cid cid.Cid, archive bool, compressLevel int, option ...DownloadOption) (io.ReadCloser, error)
+
+	// DownloadWithOptions Download configured by option
+	DownloadWithOptions(ctx context.Context, cid cid.Cid, archive bool, compressLevel int, outPath string, option ...DownloadOption) error
+}
+
+// SinkDownloader downloads to a Sink, eg: NewDownloader().(SinkDownloader)
+type SinkDownloader interface {
+	// DownloadToSink extract the data of the cid to sink as name, "" for the root of the sink,
+	// eg: NewMemSink() or NewObjectStoreSink(store, "backup").
+	// WithRawOption and WithArchiveFormatOption are ignored
+	DownloadToSink(ctx context.Context, cid cid.Cid, sink Sink, name string, option ...DownloadOption) error
+}
+
+// Lister lists directories without downloading the files, eg: NewDownloader().(Lister)
+type Lister interface {
+	// Ls list the entries of the directory cid without downloading the content of the files,
+	// a file lists itself
+	Ls(ctx context.Context, cid cid.Cid, option ...DownloadOption) ([]LsEntry, error)
+
+	// LsRecursive call fn for every entry below the directory cid as it is fetched, parents first.
+	// fn returning fs.SkipDir for a directory skips its entries, another error stops the listing.
+	// WithIncludeOption, WithExcludeOption and WithMaxDepthOption select the entries
+	LsRecursive(ctx context.Context, cid cid.Cid, fn func(entry LsEntry) error, option ...DownloadOption) error
+}
+
+var _ OptionDownloader = (*titanDownloader)(nil)
+var _ SinkDownloader = (*titanDownloader)(nil)
+var _ Lister = (*titanDownloader)(nil)
+
 func NewDownloader(option ...Option) Downloader {
 	td := &titanDownloader{}
 	for _, v := range option {
@@ -54,47 +98,206 @@ func NewDownloader(option ...Option) Downloader {
 type titanDownloader struct {
 	customGatewayAddr string
 	locatorAddr       string
+	// passed through to util.NewFetcher
+	fetcherOptions []util.FetcherOption
+	observers      util.Observers
 }
 
 // GetReader returns a read pipe
 // note: remember to close after using
 // eg: defer reader.close()
 func (t *titanDownloader) GetReader(ctx context.Context, cid cid.Cid, archive bool, compressLevel int) (io.ReadCloser, error) {
+	return t.GetReaderWithOptions(ctx, cid, archive, compressLevel)
+}
+
+// GetReaderWithOptions GetReader configured by option,
+// the report of the download is completed when the reader is drained, fails or is closed
+func (t *titanDownloader) GetReaderWithOptions(ctx context.Context, cid cid.Cid, archive bool, compressLevel int, option ...DownloadOption) (io.ReadCloser, error) {
+	settings := newDownloadSettings(option...)
+	if settings.report != nil {
+		settings.report.begin(cid, "")
+	}
+	start := time.Now()
+	reader, err := t.getReader(ctx, cid, archive, compressLevel, settings)
+	if err != nil {
+		t.complete(cid, "", settings, start, err)
+		return nil, err
+	}
+	return &completeReadCloser{ReadCloser: reader, done: func(err error) {
+		t.complete(cid, "", settings, start, err)
+	}}, nil
+}
+
+// completeReadCloser calls done once when the reader is drained, fails or is closed
+type completeReadCloser struct {
+	io.ReadCloser
+	done func(err error)
+	once sync.Once
+}
+
+func (c *completeReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if err == io.EOF {
+		c.end(nil)
+	} else if err != nil {
+		c.end(err)
+	}
+	return n, err
+}
+
+func (c *completeReadCloser) Close() error {
+	err := c.ReadCloser.Close()
+	c.end(err)
+	return err
+}
+
+func (c *completeReadCloser) end(err error) {
+	c.once.Do(func() {
+		c.done(err)
+	})
+}
+
+func (t *titanDownloader) getReader(ctx context.Context, cid cid.Cid, archive bool, compressLevel int, settings *downloadSettings) (_ io.ReadCloser, err error) {
+	ctx, span := tracer.Start(ctx, "titanDownloader.GetReader", trace.WithAttributes(attribute.String("cid", cid.String())))
+	defer func() {
+		endSpan(span, err)
+	}()
+
 	logger.Info("begin get reader with cid : ", cid.String())
-	bs := newBlockService(t.customGatewayAddr, t.locatorAddr)
-	ds := md.NewDAGService(bs)
+	ds := t.dagService(settings)
 	nd, err := ds.Get(ctx, cid)
 	if err != nil {
 		return nil, err
 	}
 
-	file, err := unixFile.NewUnixfsFile(ctx, ds, nd)
+	// the DAG is walked while the reader is consumed
+	walkCtx, walkSpan := tracer.Start(ctx, "titanDownloader.walkDAG", trace.WithAttributes(attribute.String("cid", cid.String())))
+	filter, err := newPathFilter(settings.include, settings.exclude, settings.maxDepth)
+	if err != nil {
+		endSpan(walkSpan, err)
+		return nil, err
+	}
+	walker := &dagWalker{ctx: walkCtx, ds: ds, ignoreMeta: settings.ignoreMeta, filter: filter}
+	file, err := walker.node(nd)
 	if err != nil {
+		endSpan(walkSpan, err)
 		return nil, err
 	}
 
-	return fileArchive(file, cid.String(), archive, compressLevel)
+	reader, err := nodeReader(file, cid.String(), archive, compressLevel, settings)
+	if err != nil {
+		endSpan(walkSpan, err)
+		return nil, err
+	}
+	return &spanReadCloser{ReadCloser: reader, span: walkSpan}, nil
+}
+
+// nodeReader the content of a file if raw, otherwise the archive of the file or directory
+func nodeReader(file files.Node, name string, archive bool, compressLevel int, settings *downloadSettings) (io.ReadCloser, error) {
+	if settings.raw {
+		f := files.ToFile(file)
+		if f == nil {
+			return nil, ErrIsDirectory
+		}
+		return f, nil
+	}
+	return fileArchive(file, name, archive, compressLevel, settings.format)
+}
+
+// dagService the DAG fetched through titan with the fetcher options of the call
+func (t *titanDownloader) dagService(settings *downloadSettings) ipld.DAGService {
+	bs := newBlockService(t.customGatewayAddr, t.locatorAddr, append(t.fetcherOptions[:len(t.fetcherOptions):len(t.fetcherOptions)], settings.fetcherOptions()...)...)
+	return md.NewDAGService(bs)
 }
 
 // Download data from titan to the specified directory according to the cid
 // archive: compress to tar file
 // compressLevel: compress level, eg: gzip.NoCompression
 func (t *titanDownloader) Download(ctx context.Context, cid cid.Cid, archive bool, compressLevel int, outPath string) error {
-	reader, err := t.GetReader(ctx, cid, archive, compressLevel)
-	if err != nil {
-		return err
+	return t.DownloadWithOptions(ctx, cid, archive, compressLevel, outPath)
+}
+
+// DownloadWithOptions Download configured by option
+func (t *titanDownloader) DownloadWithOptions(ctx context.Context, cid cid.Cid, archive bool, compressLevel int, outPath string, option ...DownloadOption) error {
+	settings := newDownloadSettings(option...)
+	return t.download(ctx, "titanDownloader.Download", cid, outPath, settings, func(ctx context.Context) error {
+		reader, err := t.getReader(ctx, cid, archive, compressLevel, settings)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		ow := Writer{
+			Archive:        archive,
+			Compression:    compressLevel,
+			Sync:           settings.sync,
+			Conflict:       settings.conflict,
+			Format:         settings.format,
+			Raw:            settings.raw,
+			IgnoreMetadata: settings.ignoreMeta,
+			Extract:        settings.extract,
+		}
+		logger.Debugf("%s%s", "download data to ", outPath)
+		return ow.WriteContext(ctx, reader, outPath)
+	})
+}
+
+// DownloadToSink extract the data of the cid to sink as name, "" for the root of the sink
+func (t *titanDownloader) DownloadToSink(ctx context.Context, cid cid.Cid, sink Sink, name string, option ...DownloadOption) error {
+	settings := newDownloadSettings(option...)
+	// the tar stream is extracted file by file
+	settings.raw = false
+	settings.format = nil
+	return t.download(ctx, "titanDownloader.DownloadToSink", cid, name, settings, func(ctx context.Context) error {
+		reader, err := t.getReader(ctx, cid, false, gzip.NoCompression, settings)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		ow := Writer{IgnoreMetadata: settings.ignoreMeta, Extract: settings.extract}
+		logger.Debugf("%s%s", "download data to sink as ", name)
+		return ow.WriteSink(ctx, reader, sink, name)
+	})
+}
+
+// download run write with the span, report, observers and metrics of a download to dest
+func (t *titanDownloader) download(ctx context.Context, spanName string, cid cid.Cid, dest string, settings *downloadSettings, write func(ctx context.Context) error) (err error) {
+	ctx, span := tracer.Start(ctx, spanName, trace.WithAttributes(
+		attribute.String("cid", cid.String()),
+		attribute.String("out_path", dest),
+	))
+	if settings.report != nil {
+		settings.report.begin(cid, dest)
 	}
-	defer reader.Close()
+	start := time.Now()
+	defer func() {
+		t.complete(cid, dest, settings, start, err)
+		endSpan(span, err)
+	}()
+
+	stats.Record(ctx, util.ActiveDownloads.M(1))
+	defer stats.Record(ctx, util.ActiveDownloads.M(-1))
+
+	return write(ctx)
+}
 
-	ow := Writer{
-		Archive:     archive,
-		Compression: compressLevel,
+// complete notify the observers and the report of the end of a download, and write the report file
+func (t *titanDownloader) complete(cid cid.Cid, dest string, settings *downloadSettings, start time.Time, err error) {
+	t.observers.DownloadComplete(cid, dest, time.Since(start), err)
+	if settings.report == nil {
+		return
+	}
+	settings.report.DownloadComplete(cid, dest, time.Since(start), err)
+	if settings.reportPath != "" {
+		if rerr := settings.report.WriteJSONFile(settings.reportPath); rerr != nil {
+			logger.Warn("write download report fail : ", rerr.Error())
+		}
 	}
-	logger.Debugf("%s%s", "download data to ", outPath)
-	return ow.Write(reader, outPath)
 }
 
-func fileArchive(f files.Node, name string, archive bool, compression int) (io.ReadCloser, error) {
+// fileArchive stream f as format, or as tar/gzip according to archive and compression if format is nil
+func fileArchive(f files.Node, name string, archive bool, compression int, format ArchiveFormat) (io.ReadCloser, error) {
 	cleaned := gopath.Clean(name)
 	_, filename := gopath.Split(cleaned)
 
@@ -111,6 +314,19 @@ func fileArchive(f files.Node, name string, archive bool, compression int) (io.R
 	// use a buffered writer to parallelize task
 	bufWriter := bufio.NewWriterSize(pipeWriter, defaultBufSize)
 
+	if format != nil {
+		go func() {
+			if err := format.WriteArchive(bufWriter, f, filename); checkErrAndClosePipe(err) {
+				return
+			}
+			if err := bufWriter.Flush(); checkErrAndClosePipe(err) {
+				return
+			}
+			_ = pipeWriter.Close()
+		}()
+		return pipeReader, nil
+	}
+
 	// compression determines whether to use gzip compression.
 	maybeGzw, err := newMaybeGzWriter(bufWriter, compression)
 	if checkErrAndClosePipe(err) {
@@ -131,7 +347,7 @@ func fileArchive(f files.Node, name string, archive bool, compression int) (io.R
 		// the case when the node is a file
 		r := files.ToFile(f)
 		if r == nil {
-			return nil, errors.New("file is not regular")
+			return nil, ErrIsDirectory
 		}
 
 		go func() {
@@ -143,18 +359,11 @@ func fileArchive(f files.Node, name string, archive bool, compression int) (io.R
 	} else {
 		// the case for 1. archive, and 2. not archived and not compressed, in which tar is used anyway as a transport format
 
-		// construct the tar writer
-		w, err := files.NewTarWriter(maybeGzw)
-		if checkErrAndClosePipe(err) {
-			return nil, err
-		}
-
 		go func() {
 			// write all the nodes recursively
-			if err := w.WriteFile(f, filename); checkErrAndClosePipe(err) {
+			if err := writeTar(maybeGzw, f, filename); checkErrAndClosePipe(err) {
 				return
 			}
-			_ = w.Close()     // close tar writer
 			closeGzwAndPipe() // everything seems to be ok
 		}()
 	}
@@ -5,9 +5,14 @@ import (
 	"context"
 	"github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log/v2"
+	"github.com/timtide/titan-client/util"
+	"go.opencensus.io/stats/view"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestNewDownloader(t *testing.T) {
@@ -15,6 +20,60 @@ func TestNewDownloader(t *testing.T) {
 	t.Log(downloader)
 }
 
+// activeDownloads the current value of the active downloads view
+func activeDownloads(t *testing.T) float64 {
+	rows, err := view.RetrieveData(util.ActiveDownloadsView.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) == 0 {
+		return 0
+	}
+	return rows[0].Data.(*view.SumData).Value
+}
+
+func TestDownloadActiveMetric(t *testing.T) {
+	if err := view.Register(util.ActiveDownloadsView); err != nil {
+		t.Error(err)
+		return
+	}
+	defer view.Unregister(util.ActiveDownloadsView)
+
+	// the locator holds the download until the test has seen it active
+	release := make(chan struct{})
+	locator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer locator.Close()
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer gateway.Close()
+
+	c, _ := cid.Decode("QmYLniRF9EL5CCV5hY5z9KEYqnRdhvjpCGZgvNxL8JCc2E")
+	downloader := NewDownloader(WithLocatorAddressOption(locator.URL), WithCustomGatewayAddressOption(gateway.URL), WithHTTPClientOption(locator.Client()))
+	done := make(chan error, 1)
+	go func() {
+		done <- downloader.(SinkDownloader).DownloadToSink(context.Background(), c, NewMemSink(), "")
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for activeDownloads(t) != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := activeDownloads(t); n != 1 {
+		t.Errorf("expected 1 active download, got %v", n)
+	}
+	close(release)
+	if err := <-done; err == nil {
+		t.Error("expected the download to fail")
+	}
+	if n := activeDownloads(t); n != 0 {
+		t.Errorf("expected 0 active download, got %v", n)
+	}
+}
+
 func TestTitanDownloader_Download(t *testing.T) {
 	carfiles := []string{
 		"QmT2dwc94QJypTuACcdBGLdzJGLz7m1LCvPvH43HrZdTWn",
@@ -0,0 +1,506 @@
+package titan_client
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	gopath "path"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrUnsafePath an entry path is absolute, contains ".." or is not below the root
+	ErrUnsafePath = errors.New("unsafe path")
+	// ErrUnsafeSymlink a symlink can not be extracted with the symlink policy
+	ErrUnsafeSymlink = errors.New("unsafe symlink")
+	// ErrTotalSizeLimit the files are larger than ExtractPolicy.MaxTotalBytes
+	ErrTotalSizeLimit = errors.New("total size limit exceeded")
+	// ErrFileSizeLimit a file is larger than ExtractPolicy.MaxFileSize
+	ErrFileSizeLimit = errors.New("file size limit exceeded")
+	// ErrFileCountLimit there are more entries than ExtractPolicy.MaxFiles
+	ErrFileCountLimit = errors.New("file count limit exceeded")
+	// ErrDepthLimit an entry is nested deeper than ExtractPolicy.MaxDepth
+	ErrDepthLimit = errors.New("directory depth limit exceeded")
+)
+
+// ExtractError an entry of the download breaks a safeguard of the extraction,
+// Err is one of ErrUnsafePath, ErrUnsafeSymlink or the limit errors
+type ExtractError struct {
+	// Path the path of the entry in the download
+	Path string
+	Err  error
+}
+
+func (e *ExtractError) Error() string {
+	return fmt.Sprintf("extract %s: %s", e.Path, e.Err.Error())
+}
+
+func (e *ExtractError) Unwrap() error {
+	return e.Err
+}
+
+// SymlinkPolicy what the extraction does with symlinks
+type SymlinkPolicy int
+
+const (
+	// SymlinkInternal keep symlinks pointing to entries of the download, others are dropped
+	SymlinkInternal SymlinkPolicy = iota
+	// SymlinkSkip drop all symlinks
+	SymlinkSkip
+	// SymlinkFollow replace symlinks pointing to entries of the download by a copy
+	// of their target, others are dropped. the Sink must implement fs.FS.
+	// the copies count against the limits, MaxTotalBytes and MaxFiles if unset
+	// are ten times the size and the entries of the download
+	SymlinkFollow
+	// SymlinkKeep keep all symlinks as is, they may point anywhere on the local file system.
+	// only for trusted content
+	SymlinkKeep
+)
+
+// ExtractPolicy safeguards of the extraction of untrusted downloads, a breach fails
+// the download with *ExtractError. absolute and ".." paths are always rejected,
+// zero limits are unlimited
+type ExtractPolicy struct {
+	Symlinks SymlinkPolicy
+	// MaxTotalBytes the size of all files
+	MaxTotalBytes int64
+	// MaxFileSize the size of one file
+	MaxFileSize int64
+	// MaxFiles the number of files, directories and symlinks
+	MaxFiles int
+	// MaxDepth the number of path elements below the root
+	MaxDepth int
+	// SpecialBits keep the setuid, setgid and sticky bits of the entries,
+	// they are stripped by default. only for trusted content
+	SpecialBits bool
+}
+
+// maxSymlinkHops the symlinks resolved for one path, as the usual kernel limit
+const maxSymlinkHops = 40
+
+// maxFollowGrowth how many times the size and entries of the download SymlinkFollow
+// may copy when the limits are unset
+const maxFollowGrowth = 10
+
+// extractor writes the tar stream of fileArchive to sink, as tar.Extractor of tar-utils:
+// a root directory becomes root, a root file becomes root, or is put inside root if intoDir.
+// symlinks are resolved against the entries of the download only, and created last
+// so that nothing is written through them.
+// the mode and mtime of the entries are applied unless ignoreMeta
+type extractor struct {
+	ctx  context.Context
+	sink Sink
+	// root the name of the download in the sink, empty for the root of the sink
+	root       string
+	intoDir    bool
+	ignoreMeta bool
+	policy     ExtractPolicy
+
+	files int
+	bytes int64
+	// entries the tar type of the entries by path below the root, "" is the root
+	entries  map[string]byte
+	children map[string][]string
+	links    []extractedLink
+	targets  map[string]string
+}
+
+type extractedDir struct {
+	rel    string
+	header *tar.Header
+}
+
+type extractedLink struct {
+	rel    string
+	name   string
+	target string
+}
+
+func (e *extractor) Extract(r io.Reader) error {
+	tr := tar.NewReader(r)
+	header, err := tr.Next()
+	if err == io.EOF {
+		return errors.New("empty tar file")
+	}
+	if err != nil {
+		return err
+	}
+
+	rootName := strings.TrimSuffix(header.Name, "/")
+	if err = validComponent(rootName); err != nil {
+		return &ExtractError{Path: header.Name, Err: ErrUnsafePath}
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+	case tar.TypeReg, tar.TypeSymlink:
+		name := e.root
+		if e.intoDir || name == "" {
+			name = gopath.Join(e.root, rootName)
+		}
+		// a single entry, counted as the entries of a directory
+		if err = e.count(header.Name, rootName); err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeSymlink {
+			// a symlink alone points outside the download
+			if e.policy.Symlinks != SymlinkKeep {
+				return &ExtractError{Path: header.Name, Err: ErrUnsafeSymlink}
+			}
+			err = e.symlink(name, header.Linkname)
+		} else {
+			err = e.extractFile(name, header, tr)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err = tr.Next(); err != io.EOF {
+			if err == nil {
+				err = errors.New("the root is not a directory and the tar has multiple entries")
+			}
+			return err
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported tar entry type %d of %q", header.Typeflag, header.Name)
+	}
+
+	e.entries = map[string]byte{"": tar.TypeDir}
+	e.children = make(map[string][]string)
+	e.targets = make(map[string]string)
+	if err = e.sink.MkdirAll(e.sinkName("")); err != nil {
+		return err
+	}
+	dirs := []extractedDir{{rel: "", header: header}}
+	for {
+		if err = e.ctx.Err(); err != nil {
+			return err
+		}
+		header, err = tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name := strings.TrimSuffix(header.Name, "/")
+		if !strings.HasPrefix(name, rootName+"/") {
+			return &ExtractError{Path: header.Name, Err: ErrUnsafePath}
+		}
+		rel := name[len(rootName)+1:]
+		for _, elem := range strings.Split(rel, "/") {
+			if validComponent(elem) != nil {
+				return &ExtractError{Path: header.Name, Err: ErrUnsafePath}
+			}
+		}
+		// the parents are directories of the download, never symlinks
+		parent := gopath.Dir(rel)
+		if parent == "." {
+			parent = ""
+		}
+		if e.entries[parent] != tar.TypeDir {
+			return fmt.Errorf("invalid path %q: %s is not a directory", header.Name, parent)
+		}
+		if _, ok := e.entries[rel]; ok {
+			return fmt.Errorf("duplicate entry %q", header.Name)
+		}
+		if err = e.count(header.Name, rel); err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = e.sink.MkdirAll(e.sinkName(rel))
+			dirs = append(dirs, extractedDir{rel: rel, header: header})
+		case tar.TypeReg:
+			err = e.extractFile(e.sinkName(rel), header, tr)
+		case tar.TypeSymlink:
+			if e.policy.Symlinks == SymlinkSkip {
+				continue
+			}
+			e.links = append(e.links, extractedLink{rel: rel, name: header.Name, target: header.Linkname})
+			e.targets[rel] = header.Linkname
+		default:
+			err = fmt.Errorf("unsupported tar entry type %d of %q", header.Typeflag, header.Name)
+		}
+		if err != nil {
+			return err
+		}
+		e.entries[rel] = header.Typeflag
+		e.children[parent] = append(e.children[parent], gopath.Base(rel))
+	}
+
+	if err = e.finishLinks(); err != nil {
+		return err
+	}
+
+	// writing entries changes the mtime of their directory, set it last, deepest first
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err = e.applyMeta(e.sinkName(dirs[i].rel), dirs[i].header, defaultDirMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sinkName the name in the sink of the entry rel below the root
+func (e *extractor) sinkName(rel string) string {
+	name := gopath.Join(e.root, rel)
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// count check the file count and depth limits for the entry name
+func (e *extractor) count(name, rel string) error {
+	e.files++
+	if e.policy.MaxFiles > 0 && e.files > e.policy.MaxFiles {
+		return &ExtractError{Path: name, Err: ErrFileCountLimit}
+	}
+	if e.policy.MaxDepth > 0 && strings.Count(rel, "/")+1 > e.policy.MaxDepth {
+		return &ExtractError{Path: name, Err: ErrDepthLimit}
+	}
+	return nil
+}
+
+// addBytes check the size limits for a file of size bytes
+func (e *extractor) addBytes(name string, size int64) error {
+	if e.policy.MaxFileSize > 0 && size > e.policy.MaxFileSize {
+		return &ExtractError{Path: name, Err: ErrFileSizeLimit}
+	}
+	e.bytes += size
+	if e.policy.MaxTotalBytes > 0 && e.bytes > e.policy.MaxTotalBytes {
+		return &ExtractError{Path: name, Err: ErrTotalSizeLimit}
+	}
+	return nil
+}
+
+func (e *extractor) extractFile(name string, header *tar.Header, r io.Reader) error {
+	// the size is checked before writing, tar.Reader does not return more than it
+	if err := e.addBytes(header.Name, header.Size); err != nil {
+		return err
+	}
+	if err := e.writeFile(name, header.Size, r); err != nil {
+		return err
+	}
+	return e.applyMeta(name, header, defaultFileMode)
+}
+
+func (e *extractor) writeFile(name string, size int64, r io.Reader) error {
+	w, err := e.sink.Create(e.ctx, name, size)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, r); err != nil {
+		// do not commit a partial file
+		if a, ok := w.(interface{ CloseWithError(error) error }); ok {
+			_ = a.CloseWithError(err)
+		} else {
+			_ = w.Close()
+		}
+		return err
+	}
+	return w.Close()
+}
+
+func (e *extractor) symlink(name, target string) error {
+	ss, ok := e.sink.(SymlinkSink)
+	if !ok {
+		logger.Warnf("drop symlink %s -> %s, the sink does not store symlinks", name, target)
+		return nil
+	}
+	return ss.Symlink(target, name)
+}
+
+// applyMeta set the mode and mtime of the header to name, def mode if ignoreMeta
+func (e *extractor) applyMeta(name string, header *tar.Header, def os.FileMode) error {
+	ms, ok := e.sink.(MetaSink)
+	if !ok {
+		return nil
+	}
+	if e.ignoreMeta {
+		return ms.SetMeta(name, def, time.Time{})
+	}
+	keep := fs.ModePerm
+	if e.policy.SpecialBits {
+		keep |= fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+	}
+	return ms.SetMeta(name, header.FileInfo().Mode()&keep, header.ModTime)
+}
+
+// finishLinks apply the symlink policy and create the symlinks
+func (e *extractor) finishLinks() error {
+	links := e.links
+	if e.policy.Symlinks != SymlinkKeep {
+		// dropping a link can break links resolved through it, until none is dropped
+		for dropped := true; dropped; {
+			dropped = false
+			kept := links[:0]
+			for _, l := range links {
+				if _, ok := e.resolveLink(l.rel, 0); ok {
+					kept = append(kept, l)
+					continue
+				}
+				logger.Warnf("drop symlink %s -> %s, it points outside the download", l.name, l.target)
+				delete(e.entries, l.rel)
+				dropped = true
+			}
+			links = kept
+		}
+	}
+
+	if e.policy.Symlinks != SymlinkFollow {
+		for _, l := range links {
+			if err := e.symlink(e.sinkName(l.rel), l.target); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(links) == 0 {
+		return nil
+	}
+	src, ok := e.sink.(fs.FS)
+	if !ok {
+		return errors.New("symlink follow needs a sink implementing fs.FS")
+	}
+	// nested links to directories would grow the download exponentially
+	if e.policy.MaxTotalBytes <= 0 {
+		e.policy.MaxTotalBytes = maxFollowGrowth*e.bytes + 1
+	}
+	if e.policy.MaxFiles <= 0 {
+		e.policy.MaxFiles = maxFollowGrowth * e.files
+	}
+	for _, l := range links {
+		target, _ := e.resolveLink(l.rel, 0)
+		if err := e.copyEntry(src, target, l.rel, l.name, map[string]bool{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveLink the entry the symlink rel points to, through other symlinks,
+// false if it is outside the download or does not exist
+func (e *extractor) resolveLink(rel string, hops int) (string, bool) {
+	target := e.targets[rel]
+	if target == "" || strings.HasPrefix(target, "/") || strings.ContainsRune(target, 0) {
+		return "", false
+	}
+	var cur []string
+	if parent := gopath.Dir(rel); parent != "." {
+		cur = strings.Split(parent, "/")
+	}
+	for _, elem := range strings.Split(target, "/") {
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if len(cur) == 0 {
+				return "", false
+			}
+			cur = cur[:len(cur)-1]
+			continue
+		}
+		cur = append(cur, elem)
+		p := strings.Join(cur, "/")
+		typ, ok := e.entries[p]
+		if !ok {
+			return "", false
+		}
+		if typ == tar.TypeSymlink {
+			if hops >= maxSymlinkHops {
+				return "", false
+			}
+			resolved, ok := e.resolveLink(p, hops+1)
+			if !ok {
+				return "", false
+			}
+			cur = nil
+			if resolved != "" {
+				cur = strings.Split(resolved, "/")
+			}
+		}
+	}
+	return strings.Join(cur, "/"), true
+}
+
+// copyEntry copy the entry src to dst, following the symlinks below it
+func (e *extractor) copyEntry(src fs.FS, from, to, name string, visiting map[string]bool) error {
+	if err := e.count(name, to); err != nil {
+		return err
+	}
+	if e.entries[from] != tar.TypeDir {
+		f, err := src.Open(e.sinkName(from))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if err = e.addBytes(name, info.Size()); err != nil {
+			return err
+		}
+		if err = e.writeFile(e.sinkName(to), info.Size(), f); err != nil {
+			return err
+		}
+		if ms, ok := e.sink.(MetaSink); ok {
+			return ms.SetMeta(e.sinkName(to), info.Mode().Perm(), info.ModTime())
+		}
+		return nil
+	}
+
+	if visiting[from] {
+		// a symlink to one of its parents
+		return &ExtractError{Path: name, Err: ErrUnsafeSymlink}
+	}
+	visiting[from] = true
+	defer delete(visiting, from)
+
+	if err := e.sink.MkdirAll(e.sinkName(to)); err != nil {
+		return err
+	}
+	children := append([]string(nil), e.children[from]...)
+	sort.Strings(children)
+	for _, child := range children {
+		childFrom := gopath.Join(from, child)
+		switch e.entries[childFrom] {
+		case 0:
+			// dropped symlink
+			continue
+		case tar.TypeSymlink:
+			resolved, ok := e.resolveLink(childFrom, 0)
+			if !ok {
+				continue
+			}
+			childFrom = resolved
+		}
+		if err := e.copyEntry(src, childFrom, gopath.Join(to, child), name+"/"+child, visiting); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validComponent check one element of a tar path
+func validComponent(e string) error {
+	switch e {
+	case "", ".", "..":
+		return ErrUnsafePath
+	}
+	if strings.ContainsRune(e, '/') || strings.ContainsRune(e, os.PathSeparator) || strings.ContainsRune(e, 0) {
+		return ErrUnsafePath
+	}
+	return nil
+}
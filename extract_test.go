@@ -0,0 +1,235 @@
+package titan_client
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type tarEntry struct {
+	name   string
+	typ    byte
+	body   string
+	target string
+	// mode of the entry, 0644 for files and 0755 for directories if 0
+	mode int64
+}
+
+func buildTar(t *testing.T, entries ...tarEntry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Typeflag: e.typ, Mode: 0644, Size: int64(len(e.body)), Linkname: e.target}
+		if e.typ == tar.TypeDir {
+			h.Mode = 0755
+		}
+		if e.mode != 0 {
+			h.Mode = e.mode
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = tw.Close()
+	return buf.Bytes()
+}
+
+// dirExtractor an extractor writing to the directory out, as Writer
+func dirExtractor(out string, policy ExtractPolicy) *extractor {
+	return &extractor{
+		ctx:    context.Background(),
+		sink:   NewDirSink(filepath.Dir(out)),
+		root:   filepath.Base(out),
+		policy: policy,
+	}
+}
+
+func TestExtractUnsafePath(t *testing.T) {
+	cases := [][]tarEntry{
+		{{name: "..", typ: tar.TypeDir}},
+		{{name: "/etc", typ: tar.TypeDir}},
+		{{name: "root", typ: tar.TypeDir}, {name: "root/../evil", typ: tar.TypeReg, body: "x"}},
+		{{name: "root", typ: tar.TypeDir}, {name: "root/a/../../evil", typ: tar.TypeReg, body: "x"}},
+		{{name: "root", typ: tar.TypeDir}, {name: "other/evil", typ: tar.TypeReg, body: "x"}},
+	}
+	for i, entries := range cases {
+		e := dirExtractor(filepath.Join(t.TempDir(), "out"), ExtractPolicy{})
+		err := e.Extract(bytes.NewReader(buildTar(t, entries...)))
+		var extractErr *ExtractError
+		if !errors.As(err, &extractErr) || !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("case %d: expected ErrUnsafePath, got %v", i, err)
+		}
+	}
+}
+
+func TestExtractSymlinkPolicy(t *testing.T) {
+	data := buildTar(t,
+		tarEntry{name: "root", typ: tar.TypeDir},
+		tarEntry{name: "root/f", typ: tar.TypeReg, body: "titan"},
+		tarEntry{name: "root/d1", typ: tar.TypeDir},
+		tarEntry{name: "root/d1/d2", typ: tar.TypeDir},
+		tarEntry{name: "root/d1/d2/up", typ: tar.TypeSymlink, target: ".."},
+		tarEntry{name: "root/in", typ: tar.TypeSymlink, target: "f"},
+		tarEntry{name: "root/out", typ: tar.TypeSymlink, target: "../../etc"},
+		tarEntry{name: "root/abs", typ: tar.TypeSymlink, target: "/etc/passwd"},
+		// lexically inside, escapes through up
+		tarEntry{name: "root/chain", typ: tar.TypeSymlink, target: "d1/d2/up/../.."},
+	)
+	for policy, want := range map[SymlinkPolicy]map[string]bool{
+		SymlinkInternal: {"in": true, "d1/d2/up": true},
+		SymlinkSkip:     {},
+		SymlinkKeep:     {"in": true, "d1/d2/up": true, "out": true, "abs": true, "chain": true},
+	} {
+		out := filepath.Join(t.TempDir(), "out")
+		e := dirExtractor(out, ExtractPolicy{Symlinks: policy})
+		if err := e.Extract(bytes.NewReader(data)); err != nil {
+			t.Errorf("policy %d: %v", policy, err)
+			continue
+		}
+		for _, name := range []string{"in", "d1/d2/up", "out", "abs", "chain"} {
+			_, err := os.Lstat(filepath.Join(out, name))
+			if (err == nil) != want[name] {
+				t.Errorf("policy %d: %s exists %v", policy, name, err == nil)
+			}
+		}
+	}
+
+	// follow copies the target, the link to a parent is a loop
+	out := filepath.Join(t.TempDir(), "out")
+	e := dirExtractor(out, ExtractPolicy{Symlinks: SymlinkFollow})
+	err := e.Extract(bytes.NewReader(data))
+	if !errors.Is(err, ErrUnsafeSymlink) {
+		t.Errorf("expected ErrUnsafeSymlink, got %v", err)
+	}
+	out = filepath.Join(t.TempDir(), "out")
+	e = dirExtractor(out, ExtractPolicy{Symlinks: SymlinkFollow})
+	err = e.Extract(bytes.NewReader(buildTar(t,
+		tarEntry{name: "root", typ: tar.TypeDir},
+		tarEntry{name: "root/d", typ: tar.TypeDir},
+		tarEntry{name: "root/d/f", typ: tar.TypeReg, body: "titan"},
+		tarEntry{name: "root/in", typ: tar.TypeSymlink, target: "d/f"},
+		tarEntry{name: "root/dir", typ: tar.TypeSymlink, target: "d"},
+	)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, name := range []string{"in", "dir/f"} {
+		info, err := os.Lstat(filepath.Join(out, name))
+		if err != nil || !info.Mode().IsRegular() {
+			t.Errorf("%s is not a regular file: %v", name, err)
+		}
+	}
+}
+
+func TestExtractLimits(t *testing.T) {
+	data := buildTar(t,
+		tarEntry{name: "root", typ: tar.TypeDir},
+		tarEntry{name: "root/a", typ: tar.TypeDir},
+		tarEntry{name: "root/a/b", typ: tar.TypeReg, body: "titan"},
+		tarEntry{name: "root/c", typ: tar.TypeReg, body: "titan client"},
+	)
+	for _, c := range []struct {
+		policy ExtractPolicy
+		err    error
+	}{
+		{ExtractPolicy{MaxFiles: 2}, ErrFileCountLimit},
+		{ExtractPolicy{MaxDepth: 1}, ErrDepthLimit},
+		{ExtractPolicy{MaxFileSize: 10}, ErrFileSizeLimit},
+		{ExtractPolicy{MaxTotalBytes: 16}, ErrTotalSizeLimit},
+		{ExtractPolicy{MaxFiles: 3, MaxDepth: 2, MaxFileSize: 12, MaxTotalBytes: 17}, nil},
+	} {
+		e := dirExtractor(filepath.Join(t.TempDir(), "out"), c.policy)
+		if err := e.Extract(bytes.NewReader(data)); !errors.Is(err, c.err) {
+			t.Errorf("%+v: expected %v, got %v", c.policy, c.err, err)
+		}
+	}
+}
+
+func TestExtractSpecialBits(t *testing.T) {
+	data := buildTar(t,
+		tarEntry{name: "root", typ: tar.TypeDir, mode: 01777},
+		tarEntry{name: "root/run", typ: tar.TypeReg, body: "x", mode: 04755},
+		tarEntry{name: "root/group", typ: tar.TypeReg, body: "x", mode: 02750},
+	)
+	for _, special := range []bool{false, true} {
+		sink := NewMemSink()
+		e := &extractor{ctx: context.Background(), sink: sink, root: "out", policy: ExtractPolicy{SpecialBits: special}}
+		if err := e.Extract(bytes.NewReader(data)); err != nil {
+			t.Error(err)
+			return
+		}
+		want := map[string]fs.FileMode{"out": 0777, "out/run": 0755, "out/group": 0750}
+		if special {
+			want = map[string]fs.FileMode{"out": 0777 | fs.ModeSticky, "out/run": 0755 | fs.ModeSetuid, "out/group": 0750 | fs.ModeSetgid}
+		}
+		for name, mode := range want {
+			info, err := fs.Stat(sink.FS(), name)
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			if got := info.Mode() &^ fs.ModeType; got != mode {
+				t.Errorf("special bits %v, %s: mode %v, want %v", special, name, got, mode)
+			}
+		}
+	}
+}
+
+func TestExtractRootFileLimits(t *testing.T) {
+	data := buildTar(t, tarEntry{name: "root", typ: tar.TypeReg, body: "titan"})
+	e := dirExtractor(filepath.Join(t.TempDir(), "out"), ExtractPolicy{MaxFiles: 1, MaxDepth: 1})
+	if err := e.Extract(bytes.NewReader(data)); err != nil {
+		t.Error(err)
+		return
+	}
+	if e.files != 1 || e.bytes != 5 {
+		t.Errorf("root file not counted, files %d, bytes %d", e.files, e.bytes)
+	}
+	e = dirExtractor(filepath.Join(t.TempDir(), "out"), ExtractPolicy{MaxFileSize: 4})
+	if err := e.Extract(bytes.NewReader(data)); !errors.Is(err, ErrFileSizeLimit) {
+		t.Errorf("expected ErrFileSizeLimit, got %v", err)
+	}
+}
+
+func TestExtractFollowLimits(t *testing.T) {
+	// every level links twice to the level below, 2^8 copies of the file
+	entries := []tarEntry{
+		{name: "root", typ: tar.TypeDir},
+		{name: "root/d0", typ: tar.TypeDir},
+		{name: "root/d0/f", typ: tar.TypeReg, body: strings.Repeat("x", 100)},
+	}
+	for i := 1; i <= 8; i++ {
+		dir := fmt.Sprintf("root/d%d", i)
+		target := fmt.Sprintf("../d%d", i-1)
+		entries = append(entries,
+			tarEntry{name: dir, typ: tar.TypeDir},
+			tarEntry{name: dir + "/a", typ: tar.TypeSymlink, target: target},
+			tarEntry{name: dir + "/b", typ: tar.TypeSymlink, target: target},
+		)
+	}
+	data := buildTar(t, entries...)
+
+	e := dirExtractor(filepath.Join(t.TempDir(), "out"), ExtractPolicy{Symlinks: SymlinkFollow})
+	if err := e.Extract(bytes.NewReader(data)); !errors.Is(err, ErrTotalSizeLimit) && !errors.Is(err, ErrFileCountLimit) {
+		t.Errorf("expected a limit error, got %v", err)
+	}
+	e = dirExtractor(filepath.Join(t.TempDir(), "out"), ExtractPolicy{Symlinks: SymlinkFollow, MaxTotalBytes: 100 << 10, MaxFiles: 2000})
+	if err := e.Extract(bytes.NewReader(data)); err != nil {
+		t.Error(err)
+	}
+	e = dirExtractor(filepath.Join(t.TempDir(), "out"), ExtractPolicy{Symlinks: SymlinkFollow, MaxTotalBytes: 10 << 10, MaxFiles: 2000})
+	if err := e.Extract(bytes.NewReader(data)); !errors.Is(err, ErrTotalSizeLimit) {
+		t.Errorf("expected ErrTotalSizeLimit, got %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package titan_client
+
+import (
+	"github.com/ipfs/go-cid"
+	gopath "path"
+	"strings"
+)
+
+// pathFilter selects the entries of a directory download by their path below the root,
+// slash separated, eg: "data/2023/a.parquet".
+// a pattern without slash matches the name of an entry at any level, eg: "*.parquet",
+// a pattern with slash matches the path from the root, eg: "data/2023" or "/README.md".
+// an included directory includes all its entries, an excluded one none of them
+type pathFilter struct {
+	include  []pathPattern
+	exclude  []pathPattern
+	maxDepth int
+}
+
+type pathPattern struct {
+	glob string
+	// anchored matches the path from the root, not the name
+	anchored bool
+}
+
+func newPathFilter(include, exclude []string, maxDepth int) (*pathFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 && maxDepth <= 0 {
+		return nil, nil
+	}
+	f := &pathFilter{maxDepth: maxDepth}
+	var err error
+	if f.include, err = parsePatterns(include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = parsePatterns(exclude); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func parsePatterns(globs []string) ([]pathPattern, error) {
+	patterns := make([]pathPattern, 0, len(globs))
+	for _, glob := range globs {
+		if _, err := gopath.Match(glob, ""); err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, pathPattern{
+			glob:     strings.Trim(glob, "/"),
+			anchored: strings.Contains(strings.TrimSuffix(glob, "/"), "/"),
+		})
+	}
+	return patterns, nil
+}
+
+func (pp pathPattern) match(p, name string) bool {
+	if pp.anchored {
+		ok, _ := gopath.Match(pp.glob, p)
+		return ok
+	}
+	ok, _ := gopath.Match(pp.glob, name)
+	return ok
+}
+
+// excluded whether p matches an exclude pattern or is deeper than maxDepth,
+// its parents are checked before
+func (f *pathFilter) excluded(p string) bool {
+	if f == nil {
+		return false
+	}
+	elems := strings.Split(p, "/")
+	if f.maxDepth > 0 && len(elems) > f.maxDepth {
+		return true
+	}
+	for _, pattern := range f.exclude {
+		if pattern.match(p, elems[len(elems)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// included whether p or one of its parents matches an include pattern
+func (f *pathFilter) included(p string) bool {
+	if f == nil || len(f.include) == 0 {
+		return true
+	}
+	elems := strings.Split(p, "/")
+	for i := range elems {
+		prefix := strings.Join(elems[:i+1], "/")
+		for _, pattern := range f.include {
+			if pattern.match(prefix, elems[i]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mayBeDirectory whether the entry p, not included itself, must be fetched as it may be
+// a directory with included entries. a raw block is always a file
+func (f *pathFilter) mayBeDirectory(p string, c cid.Cid) bool {
+	return c.Prefix().Codec != cid.Raw && f.mayContain(p)
+}
+
+// mayContain whether the entries of the directory p may be included
+func (f *pathFilter) mayContain(p string) bool {
+	if f.included(p) {
+		return true
+	}
+	depth := strings.Count(p, "/") + 1
+	for _, pattern := range f.include {
+		if !pattern.anchored {
+			// matches names at any level
+			return true
+		}
+		elems := strings.Split(pattern.glob, "/")
+		if len(elems) <= depth {
+			continue
+		}
+		if ok, _ := gopath.Match(strings.Join(elems[:depth], "/"), p); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package titan_client
+
+import (
+	"context"
+	"github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
+	md "github.com/ipfs/go-merkledag"
+	ft "github.com/ipfs/go-unixfs"
+	"sort"
+	"testing"
+)
+
+func TestPathFilter(t *testing.T) {
+	f, err := newPathFilter([]string{"*.parquet", "data/2023"}, []string{"tmp", "/data/2023/skip.parquet"}, 3)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for p, want := range map[string]bool{
+		"a.parquet":               true,
+		"x/y/a.parquet":           true,
+		"x/y/z/a.parquet":         false, // too deep
+		"a.csv":                   false,
+		"data/2023/a.csv":         true,
+		"data/2023/skip.parquet":  false,
+		"data/2022/a.csv":         false,
+		"tmp/a.parquet":           false, // its parent is excluded
+		"data/2023/tmp/a.parquet": false,
+	} {
+		got := true
+		for i := range p {
+			if p[i] == '/' && f.excluded(p[:i]) {
+				got = false
+			}
+		}
+		got = got && !f.excluded(p) && f.included(p)
+		if got != want {
+			t.Errorf("%s: got %v, want %v", p, got, want)
+		}
+	}
+
+	anchored, _ := newPathFilter([]string{"data/2023"}, nil, 0)
+	for p, want := range map[string]bool{"data": true, "data/2023": true, "data/2022": false, "src": false} {
+		if anchored.mayContain(p) != want {
+			t.Errorf("mayContain %s: want %v", p, want)
+		}
+	}
+
+	if _, err = newPathFilter([]string{"["}, nil, 0); err == nil {
+		t.Error("expected bad pattern error")
+	}
+}
+
+// countingDAG counts the nodes fetched
+type countingDAG struct {
+	mapDAG
+	gets map[cid.Cid]int
+}
+
+func (c *countingDAG) Get(ctx context.Context, k cid.Cid) (ipld.Node, error) {
+	c.gets[k]++
+	return c.mapDAG.Get(ctx, k)
+}
+
+func TestWalkerFilter(t *testing.T) {
+	ctx := context.Background()
+	dag := &countingDAG{mapDAG: mapDAG{}, gets: map[cid.Cid]int{}}
+	newFile := func(data string) *md.ProtoNode {
+		nd := md.NodeWithData(ft.FilePBData([]byte(data), uint64(len(data))))
+		_ = dag.Add(ctx, nd)
+		return nd
+	}
+	newDir := func(entries map[string]ipld.Node) *md.ProtoNode {
+		nd := md.NodeWithData(ft.FolderPBData())
+		for name, child := range entries {
+			_ = nd.AddNodeLink(name, child)
+		}
+		_ = dag.Add(ctx, nd)
+		return nd
+	}
+	skipped := newDir(map[string]ipld.Node{"big.parquet": newFile("big")})
+	csv := newFile("csv")
+	raw := md.NewRawNode([]byte("raw"))
+	_ = dag.Add(ctx, raw)
+	a := newFile("a")
+	sub := newDir(map[string]ipld.Node{"b.parquet": newFile("b")})
+	root := newDir(map[string]ipld.Node{
+		"a.parquet": a,
+		"a.csv":     csv,
+		"c.bin":     raw,
+		"tmp":       skipped,
+		"sub":       sub,
+	})
+
+	filter, _ := newPathFilter([]string{"*.parquet"}, []string{"tmp"}, 0)
+	walker := &dagWalker{ctx: ctx, ds: dag, filter: filter}
+	node, err := walker.node(root)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var got []string
+	err = files.Walk(node, func(fpath string, nd files.Node) error {
+		if _, ok := nd.(files.File); ok {
+			got = append(got, fpath)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	sort.Strings(got)
+	if len(got) != 2 || got[0] != "a.parquet" || got[1] != "sub/b.parquet" {
+		t.Errorf("unexpected files %v", got)
+	}
+	if dag.gets[skipped.Cid()] != 0 {
+		t.Error("excluded directory fetched")
+	}
+	// a raw block is a file, a dag-pb one may be a directory with parquet files
+	if dag.gets[raw.Cid()] != 0 || dag.gets[csv.Cid()] != 1 {
+		t.Errorf("unexpected fetches, raw %d, csv %d", dag.gets[raw.Cid()], dag.gets[csv.Cid()])
+	}
+
+	// an anchored pattern fetches only the directories on its way
+	dag.gets = map[cid.Cid]int{}
+	filter, _ = newPathFilter([]string{"sub/*.parquet"}, nil, 0)
+	walker = &dagWalker{ctx: ctx, ds: dag, filter: filter}
+	if node, err = walker.node(root); err != nil {
+		t.Error(err)
+		return
+	}
+	got = nil
+	err = files.Walk(node, func(fpath string, nd files.Node) error {
+		got = append(got, fpath)
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(got) != 3 || got[1] != "sub" || got[2] != "sub/b.parquet" {
+		t.Errorf("unexpected entries %v", got)
+	}
+	fetches := 0
+	for _, n := range dag.gets {
+		fetches += n
+	}
+	if fetches != 2 || dag.gets[sub.Cid()] != 1 || dag.gets[a.Cid()] != 0 {
+		t.Errorf("unexpected fetches %d %v", fetches, dag.gets)
+	}
+}
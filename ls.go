@@ -0,0 +1,208 @@
+package titan_client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	md "github.com/ipfs/go-merkledag"
+	ft "github.com/ipfs/go-unixfs"
+	uio "github.com/ipfs/go-unixfs/io"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"io/fs"
+	gopath "path"
+)
+
+// EntryType the UnixFS type of a listed entry
+type EntryType int
+
+const (
+	EntryFile EntryType = iota
+	EntryDirectory
+	EntrySymlink
+)
+
+func (t EntryType) String() string {
+	switch t {
+	case EntryFile:
+		return "file"
+	case EntryDirectory:
+		return "directory"
+	case EntrySymlink:
+		return "symlink"
+	default:
+		return fmt.Sprintf("EntryType(%d)", int(t))
+	}
+}
+
+// LsEntry an entry listed by Ls or LsRecursive
+type LsEntry struct {
+	Name string
+	// Path below the listed cid, slash separated, eg: "data/2023/a.parquet"
+	Path string
+	Cid  cid.Cid
+	Type EntryType
+	// Size the content size of a file, the length of the target of a symlink.
+	// for a directory the size of its DAG, blocks and UnixFS overhead included,
+	// not the sum of its files
+	Size uint64
+}
+
+// Ls list the entries of the directory cid without downloading the content of the files,
+// a file lists itself
+func (t *titanDownloader) Ls(ctx context.Context, cid cid.Cid, option ...DownloadOption) ([]LsEntry, error) {
+	var entries []LsEntry
+	err := t.ls(ctx, "titanDownloader.Ls", cid, false, func(entry LsEntry) error {
+		entries = append(entries, entry)
+		return nil
+	}, option...)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// LsRecursive call fn for every entry below the directory cid as it is fetched, parents first
+func (t *titanDownloader) LsRecursive(ctx context.Context, cid cid.Cid, fn func(entry LsEntry) error, option ...DownloadOption) error {
+	return t.ls(ctx, "titanDownloader.LsRecursive", cid, true, fn, option...)
+}
+
+func (t *titanDownloader) ls(ctx context.Context, spanName string, cid cid.Cid, recursive bool, fn func(entry LsEntry) error, option ...DownloadOption) (err error) {
+	ctx, span := tracer.Start(ctx, spanName, trace.WithAttributes(attribute.String("cid", cid.String())))
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	settings := newDownloadSettings(option...)
+	filter, err := newPathFilter(settings.include, settings.exclude, settings.maxDepth)
+	if err != nil {
+		return err
+	}
+	ds := t.dagService(settings)
+	nd, err := ds.Get(ctx, cid)
+	if err != nil {
+		return err
+	}
+	l := &dagLister{ctx: ctx, ds: ds, filter: filter, recursive: recursive}
+	return l.list(nd, cid.String(), fn)
+}
+
+// dagLister lists UnixFS directories, only the root node of every entry is fetched
+// to know its type and size, the blocks of the files are not
+type dagLister struct {
+	ctx       context.Context
+	ds        ipld.DAGService
+	filter    *pathFilter
+	recursive bool
+}
+
+// list the entries of nd, the root named name
+func (l *dagLister) list(nd ipld.Node, name string, fn func(entry LsEntry) error) error {
+	entry, err := lsEntry(nd, name, name)
+	if err != nil {
+		return err
+	}
+	if entry.Type != EntryDirectory {
+		err = fn(entry)
+		if err == fs.SkipDir {
+			return nil
+		}
+		return err
+	}
+	return l.directory(nd, "", fn)
+}
+
+func (l *dagLister) directory(nd ipld.Node, p string, fn func(entry LsEntry) error) error {
+	dir, err := uio.NewDirectoryFromNode(l.ds, nd)
+	if err != nil {
+		return err
+	}
+	// the shards of a HAMT directory are walked by go-unixfs, links are the entries
+	links, err := dir.Links(l.ctx)
+	if err != nil {
+		return err
+	}
+
+	selected := links[:0]
+	keys := make([]cid.Cid, 0, len(links))
+	for _, link := range links {
+		// skip before fetching when the type does not matter
+		lp := gopath.Join(p, link.Name)
+		if l.filter.excluded(lp) || !l.filter.included(lp) && !l.filter.mayBeDirectory(lp, link.Cid) {
+			continue
+		}
+		selected = append(selected, link)
+		keys = append(keys, link.Cid)
+	}
+
+	// the root nodes of the entries are fetched together, in order,
+	// the fetches still running are cancelled when fn stops the listing
+	ctx, cancel := context.WithCancel(l.ctx)
+	defer cancel()
+	promises := ipld.GetNodes(ctx, l.ds, keys)
+	for i, link := range selected {
+		child, err := promises[i].Get(ctx)
+		if err != nil {
+			return err
+		}
+		lp := gopath.Join(p, link.Name)
+		entry, err := lsEntry(child, link.Name, lp)
+		if err != nil {
+			return err
+		}
+		if entry.Type != EntryDirectory && !l.filter.included(lp) {
+			continue
+		}
+
+		err = fn(entry)
+		if err == fs.SkipDir {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if l.recursive && entry.Type == EntryDirectory {
+			if err = l.directory(child, lp, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lsEntry the entry of nd from its root node
+func lsEntry(nd ipld.Node, name, p string) (LsEntry, error) {
+	entry := LsEntry{Name: name, Path: p, Cid: nd.Cid()}
+	switch dn := nd.(type) {
+	case *md.ProtoNode:
+		fsn, err := ft.FSNodeFromBytes(dn.Data())
+		if err != nil {
+			return LsEntry{}, err
+		}
+		switch fsn.Type() {
+		case ft.TDirectory, ft.THAMTShard:
+			size, err := dn.Size()
+			if err != nil {
+				return LsEntry{}, err
+			}
+			entry.Type = EntryDirectory
+			entry.Size = size
+		case ft.TSymlink:
+			entry.Type = EntrySymlink
+			entry.Size = uint64(len(fsn.Data()))
+		case ft.TFile, ft.TRaw:
+			entry.Type = EntryFile
+			entry.Size = fsn.FileSize()
+		default:
+			return LsEntry{}, fmt.Errorf("unsupported UnixFS type %s of %s", fsn.Type(), name)
+		}
+	case *md.RawNode:
+		entry.Type = EntryFile
+		entry.Size = uint64(len(dn.RawData()))
+	default:
+		return LsEntry{}, errors.New("unknown node type")
+	}
+	return entry, nil
+}
@@ -0,0 +1,190 @@
+package titan_client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	md "github.com/ipfs/go-merkledag"
+	ft "github.com/ipfs/go-unixfs"
+	"github.com/ipfs/go-unixfs/hamt"
+	"io/fs"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestDagLister(t *testing.T) {
+	ctx := context.Background()
+	dag := mapDAG{}
+
+	// a large file, its leaf is not in the DAG and must not be fetched
+	big := md.NodeWithData(ft.FilePBData(nil, 1<<20))
+	_ = big.AddRawLink("", &ipld.Link{Cid: md.NewRawNode([]byte("missing")).Cid(), Size: 1 << 20})
+	_ = dag.Add(ctx, big)
+
+	// a HAMT sharded directory
+	shard, err := hamt.NewShard(dag, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		f := md.NewRawNode([]byte(fmt.Sprintf("file %03d", i)))
+		_ = dag.Add(ctx, f)
+		if err = shard.Set(ctx, fmt.Sprintf("%03d.txt", i), f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sharded, err := shard.Node()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, _ := ft.SymlinkData("big.bin")
+	link := md.NodeWithData(target)
+	_ = dag.Add(ctx, link)
+
+	root := md.NodeWithData(ft.FolderPBData())
+	_ = root.AddNodeLink("big.bin", big)
+	_ = root.AddNodeLink("link", link)
+	_ = root.AddNodeLink("shard", sharded)
+	_ = dag.Add(ctx, root)
+
+	var entries []LsEntry
+	l := &dagLister{ctx: ctx, ds: dag}
+	err = l.list(root, root.Cid().String(), func(entry LsEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(entries) != 3 {
+		t.Errorf("expected 3 entries, got %v", entries)
+		return
+	}
+	for _, want := range []LsEntry{
+		{Name: "big.bin", Path: "big.bin", Cid: big.Cid(), Type: EntryFile, Size: 1 << 20},
+		{Name: "link", Path: "link", Cid: link.Cid(), Type: EntrySymlink, Size: 7},
+	} {
+		found := false
+		for _, e := range entries {
+			if e.Name == want.Name {
+				found = e == want
+			}
+		}
+		if !found {
+			t.Errorf("expected %+v in %v", want, entries)
+		}
+	}
+
+	var paths []string
+	l = &dagLister{ctx: ctx, ds: dag, recursive: true}
+	err = l.list(root, root.Cid().String(), func(entry LsEntry) error {
+		if entry.Path == "link" {
+			return nil
+		}
+		paths = append(paths, entry.Path)
+		if entry.Type == EntryFile && entry.Path != "big.bin" && entry.Size != 8 {
+			t.Errorf("%s: size %d", entry.Path, entry.Size)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	sort.Strings(paths)
+	if len(paths) != 102 || paths[0] != "big.bin" || paths[1] != "shard" || paths[2] != "shard/000.txt" {
+		t.Errorf("unexpected paths %d %v", len(paths), paths[:3])
+	}
+
+	// skip the shard and filter the files
+	paths = nil
+	filter, _ := newPathFilter([]string{"*.bin", "shard/001.txt"}, nil, 0)
+	l = &dagLister{ctx: ctx, ds: dag, filter: filter, recursive: true}
+	err = l.list(root, root.Cid().String(), func(entry LsEntry) error {
+		paths = append(paths, entry.Path)
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	sort.Strings(paths)
+	if fmt.Sprint(paths) != "[big.bin shard shard/001.txt]" {
+		t.Errorf("unexpected filtered paths %v", paths)
+	}
+	err = l.list(root, root.Cid().String(), func(entry LsEntry) error {
+		if entry.Type == EntryDirectory {
+			return fs.SkipDir
+		}
+		if entry.Path != "big.bin" {
+			t.Errorf("%s listed in a skipped directory", entry.Path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// stallingDAG returns the node answer if requested by GetMany and stalls the others
+// until ctx is done. GetMany may get the cids in any order
+type stallingDAG struct {
+	mapDAG
+	answer    cid.Cid
+	cancelled chan struct{}
+}
+
+func (s *stallingDAG) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	out := make(chan *ipld.NodeOption, 1)
+	for _, c := range cids {
+		if c == s.answer {
+			nd, err := s.mapDAG.Get(ctx, c)
+			out <- &ipld.NodeOption{Node: nd, Err: err}
+		}
+	}
+	go func() {
+		<-ctx.Done()
+		close(s.cancelled)
+		close(out)
+	}()
+	return out
+}
+
+func TestDagListerCancel(t *testing.T) {
+	ctx := context.Background()
+	dag := &stallingDAG{mapDAG: mapDAG{}, cancelled: make(chan struct{})}
+	root := md.NodeWithData(ft.FolderPBData())
+	for i := 0; i < 10; i++ {
+		f := md.NewRawNode([]byte(fmt.Sprintf("file %d", i)))
+		_ = dag.Add(ctx, f)
+		_ = root.AddNodeLink(fmt.Sprintf("%d.txt", i), f)
+	}
+
+	// the first entry is listed, the fetches of the others are pending
+	dag.answer = root.Links()[0].Cid
+	// a regression fails instead of hanging
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	stop := errors.New("stop")
+	l := &dagLister{ctx: ctx, ds: dag}
+	err := l.list(root, root.Cid().String(), func(entry LsEntry) error {
+		return stop
+	})
+	if err != stop {
+		t.Errorf("expected stop, got %v", err)
+	}
+	select {
+	case <-dag.cancelled:
+		if ctx.Err() != nil {
+			t.Error("the fetches were not cancelled before the deadline")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("the fetches were not cancelled")
+	}
+}
@@ -1,7 +1,142 @@
 package titan_client
 
+import (
+	"crypto"
+	"github.com/timtide/titan-client/util"
+	"net/http"
+)
+
 type Option func(td *titanDownloader)
 
+// DownloadOption configures a single call of OptionDownloader, SinkDownloader or Lister
+type DownloadOption func(ds *downloadSettings)
+
+type downloadSettings struct {
+	report     *Report
+	reportPath string
+	sync       bool
+	conflict   ConflictPolicy
+	format     ArchiveFormat
+	raw        bool
+	ignoreMeta bool
+	extract    ExtractPolicy
+	include    []string
+	exclude    []string
+	maxDepth   int
+}
+
+func newDownloadSettings(option ...DownloadOption) *downloadSettings {
+	ds := &downloadSettings{}
+	for _, v := range option {
+		v(ds)
+	}
+	if ds.report == nil && ds.reportPath != "" {
+		ds.report = &Report{}
+	}
+	return ds
+}
+
+// fetcherOptions the per call fetcher options
+func (ds *downloadSettings) fetcherOptions() []util.FetcherOption {
+	var options []util.FetcherOption
+	if ds.report != nil {
+		options = append(options, util.WithObserverOption(ds.report))
+	}
+	return options
+}
+
+// WithSyncOption fsync the output before it is renamed into place
+func WithSyncOption() DownloadOption {
+	return func(ds *downloadSettings) {
+		ds.sync = true
+	}
+}
+
+// WithConflictOption what DownloadWithOptions does if outPath already exists, default ConflictMerge
+func WithConflictOption(policy ConflictPolicy) DownloadOption {
+	return func(ds *downloadSettings) {
+		ds.conflict = policy
+	}
+}
+
+// WithArchiveFormatOption download as an archive of format, eg: NewZipFormat(flate.DefaultCompression).
+// the archive and compressLevel arguments are ignored
+func WithArchiveFormatOption(format ArchiveFormat) DownloadOption {
+	return func(ds *downloadSettings) {
+		ds.format = format
+	}
+}
+
+// WithRawOption stream the content of a file as is, without the tar envelope.
+// the archive and compressLevel arguments and WithArchiveFormatOption are ignored,
+// a directory fails with ErrIsDirectory, download it as an archive instead
+func WithRawOption() DownloadOption {
+	return func(ds *downloadSettings) {
+		ds.raw = true
+	}
+}
+
+// WithIgnoreMetadataOption drop the UnixFS mode and mtime of the files,
+// they get the default permissions, 0644 and 0755, and the current time
+func WithIgnoreMetadataOption() DownloadOption {
+	return func(ds *downloadSettings) {
+		ds.ignoreMeta = true
+	}
+}
+
+// WithExtractPolicyOption the symlink policy and the limits of the extraction to outPath,
+// eg: ExtractPolicy{MaxTotalBytes: 10 << 30, MaxFiles: 100000}.
+// default only keeps symlinks inside the download, without limits
+func WithExtractPolicyOption(policy ExtractPolicy) DownloadOption {
+	return func(ds *downloadSettings) {
+		ds.extract = policy
+	}
+}
+
+// WithIncludeOption download only the entries of a directory matching one of the glob patterns,
+// and their content if they are directories.
+// a pattern without slash matches names at any level, eg: "*.parquet",
+// with slash it matches the path below the root, eg: "data/2023".
+// the directories on the way are kept even if empty. entries that do not match are not fetched,
+// except the root block of those that may be directories with matching entries:
+// with a pattern without slash, every entry that is not a raw block
+func WithIncludeOption(patterns ...string) DownloadOption {
+	return func(ds *downloadSettings) {
+		ds.include = append(ds.include, patterns...)
+	}
+}
+
+// WithExcludeOption skip the entries of a directory matching one of the glob patterns,
+// as WithIncludeOption. excluded directories are not fetched at all
+func WithExcludeOption(patterns ...string) DownloadOption {
+	return func(ds *downloadSettings) {
+		ds.exclude = append(ds.exclude, patterns...)
+	}
+}
+
+// WithMaxDepthOption download only the entries up to depth levels below the root directory,
+// 1 for its direct entries. deeper directories are not fetched
+func WithMaxDepthOption(depth int) DownloadOption {
+	return func(ds *downloadSettings) {
+		ds.maxDepth = depth
+	}
+}
+
+// WithReportOption fill report with the summary of the download
+func WithReportOption(report *Report) DownloadOption {
+	return func(ds *downloadSettings) {
+		ds.report = report
+	}
+}
+
+// WithReportFileOption write the summary of the download as JSON to path when DownloadWithOptions returns,
+// or when the reader of GetReaderWithOptions is drained, fails or is closed
+func WithReportFileOption(path string) DownloadOption {
+	return func(ds *downloadSettings) {
+		ds.reportPath = path
+	}
+}
+
 // WithCustomGatewayAddressOption custom set gateway url
 // eg: http://127.0.0.1:5001 or https://ipfs.io/ipfs/
 // If you use the local port as the gateway,
@@ -17,3 +152,93 @@ func WithLocatorAddressOption(locatorAddr string) Option {
 		td.locatorAddr = locatorAddr
 	}
 }
+
+// WithMaxBlockSizeOption the largest block accepted from edge node or gateway, bytes.
+// a misbehaving node can not make the client allocate more than that for one block
+func WithMaxBlockSizeOption(size int64) Option {
+	return func(td *titanDownloader) {
+		td.fetcherOptions = append(td.fetcherOptions, util.WithMaxBlockSizeOption(size))
+	}
+}
+
+// WithHTTPClientOption the http client shared by edge node, gateway and locator requests,
+// eg: with a HTTP/SOCKS5 proxy, custom CA bundle, mTLS or keep-alive tuning
+func WithHTTPClientOption(client *http.Client) Option {
+	return func(td *titanDownloader) {
+		td.fetcherOptions = append(td.fetcherOptions, util.WithHTTPClientOption(client))
+	}
+}
+
+// WithRoundTripperOption the transport shared by edge node, gateway and locator requests,
+// ignored if WithHTTPClientOption is set
+func WithRoundTripperOption(rt http.RoundTripper) Option {
+	return func(td *titanDownloader) {
+		td.fetcherOptions = append(td.fetcherOptions, util.WithRoundTripperOption(rt))
+	}
+}
+
+// WithHeaderOption extra headers sent to edge nodes, gateways and locator
+func WithHeaderOption(header http.Header) Option {
+	return func(td *titanDownloader) {
+		td.fetcherOptions = append(td.fetcherOptions, util.WithHeaderOption(header))
+	}
+}
+
+// WithLimitsOption throttle the bandwidth and locator calls of downloads,
+// l can be changed at runtime and shared between downloaders
+func WithLimitsOption(l *util.Limits) Option {
+	return func(td *titanDownloader) {
+		td.fetcherOptions = append(td.fetcherOptions, util.WithLimitsOption(l))
+	}
+}
+
+// WithSignerOption the identity used with locator, eg: loaded by util.LoadSignerFile
+// or backed by an HSM. default is the process identity of util.GetSigner
+func WithSignerOption(signer util.Signer) Option {
+	return func(td *titanDownloader) {
+		td.fetcherOptions = append(td.fetcherOptions, util.WithSignerOption(signer))
+	}
+}
+
+// WithKeySignerOption the identity used with locator of any key type,
+// eg: util.NewEd25519Signer or loaded by util.LoadKeySignerFile
+func WithKeySignerOption(signer util.KeySigner) Option {
+	return func(td *titanDownloader) {
+		td.fetcherOptions = append(td.fetcherOptions, util.WithKeySignerOption(signer))
+	}
+}
+
+// WithSchedulerPublicKeyOption verify the download infos returned by locator
+// with the scheduler public key before contacting the edge nodes,
+// signature gives the message and sign the scheduler made, see util.ParsePublicKeyPem
+func WithSchedulerPublicKeyOption(publicKey crypto.PublicKey, signature util.DownloadInfoSignature) Option {
+	return func(td *titanDownloader) {
+		td.fetcherOptions = append(td.fetcherOptions, util.WithSchedulerPublicKeyOption(publicKey, signature))
+	}
+}
+
+// WithSelectorOption the strategy picking the edge node of every block,
+// eg: util.NewConsistentHashSelector(). default is util.NewWeightedSelector(nil)
+func WithSelectorOption(selector util.Selector) Option {
+	return func(td *titanDownloader) {
+		td.fetcherOptions = append(td.fetcherOptions, util.WithSelectorOption(selector))
+	}
+}
+
+// WithBlockCacheOption keep fetched blocks in memory, intermediate nodes
+// requested again while walking the DAG are not downloaded twice.
+// pass the same cache to reuse blocks across downloads, see util.NewBlockCache
+func WithBlockCacheOption(cache *util.BlockCache) Option {
+	return func(td *titanDownloader) {
+		td.fetcherOptions = append(td.fetcherOptions, util.WithBlockCacheOption(cache))
+	}
+}
+
+// WithObserverOption receive the events of the block downloads and
+// DownloadComplete, can be given several times
+func WithObserverOption(observer util.Observer) Option {
+	return func(td *titanDownloader) {
+		td.observers = append(td.observers, observer)
+		td.fetcherOptions = append(td.fetcherOptions, util.WithObserverOption(observer))
+	}
+}
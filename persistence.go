@@ -1,52 +1,542 @@
 package titan_client
 
 import (
+	"bytes"
 	"compress/gzip"
-	"github.com/ipfs/tar-utils"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
+// Writer writes the download to fpath. the output is written to a temporary
+// path in the same directory and renamed on success, a failed or cancelled
+// download leaves nothing behind. merging into an existing directory moves
+// the entries one by one and is not atomic
 type Writer struct {
 	Archive     bool
 	Compression int
+	// Sync fsync the output before it is renamed into place
+	Sync bool
+	// Format the archive format, Archive and Compression are ignored if set
+	Format ArchiveFormat
+	// IgnoreMetadata extract with default permissions and the current time instead of
+	// the mode and mtime of the tar entries
+	IgnoreMetadata bool
+	// Extract the safeguards of the extraction, the download comes from third parties
+	Extract ExtractPolicy
+	// Raw the reader is the plain content of a file, written as is to fpath
+	Raw bool
+	// Conflict what to do if the output path already exists, default ConflictMerge
+	Conflict ConflictPolicy
 }
 
+// ErrOutputExists the output path exists and the policy is ConflictFail
+var ErrOutputExists = errors.New("output path already exists")
+
+// ConflictPolicy what Writer does with an existing output path
+type ConflictPolicy int
+
+const (
+	// ConflictMerge extract into an existing directory, files are rewritten only if
+	// their content differs and other files are kept. an existing file is replaced
+	// if it differs. a file downloaded into an existing directory is put inside it,
+	// a directory is never replaced by a file. the merge is not atomic, a failure
+	// leaves the entries moved so far
+	ConflictMerge ConflictPolicy = iota
+	// ConflictOverwrite replace the existing file or directory
+	ConflictOverwrite
+	// ConflictFail fail with ErrOutputExists, nothing is written
+	ConflictFail
+	// ConflictSkipUnchanged keep the existing output if it is identical to the download,
+	// replace it otherwise
+	ConflictSkipUnchanged
+	// ConflictRename write to the first free "name (1).ext", "name (2).ext", ...
+	ConflictRename
+)
+
+// maxRename the largest suffix tried by ConflictRename
+const maxRename = 10000
+
 func (gw *Writer) Write(r io.Reader, fpath string) error {
-	if gw.Archive || gw.Compression != gzip.NoCompression {
+	return gw.WriteContext(context.Background(), r, fpath)
+}
+
+// WriteContext as Write, the extraction stops when ctx is done
+func (gw *Writer) WriteContext(ctx context.Context, r io.Reader, fpath string) error {
+	if gw.Raw {
+		return gw.writeFile(r, fpath)
+	}
+	if gw.Format != nil || gw.Archive || gw.Compression != gzip.NoCompression {
 		return gw.writeArchive(r, fpath)
 	}
-	return gw.writeExtracted(r, fpath)
+	return gw.writeExtracted(ctx, r, fpath)
+}
+
+// WriteSink extract the tar stream r to sink as name, "" for the root of the sink.
+// the files are written one by one as they are read, the other fields than
+// IgnoreMetadata and Extract are not used
+func (gw *Writer) WriteSink(ctx context.Context, r io.Reader, sink Sink, name string) error {
+	if name == "." {
+		name = ""
+	}
+	if name != "" && !fs.ValidPath(name) {
+		return &ExtractError{Path: name, Err: ErrUnsafePath}
+	}
+	extractor := &extractor{
+		ctx:        ctx,
+		sink:       sink,
+		root:       name,
+		ignoreMeta: gw.IgnoreMetadata,
+		policy:     gw.Extract,
+	}
+	return extractor.Extract(r)
 }
 
 func (gw *Writer) writeArchive(r io.Reader, fpath string) error {
-	// adjust file name if tar
-	if gw.Archive {
+	if gw.Format != nil {
+		if !strings.HasSuffix(fpath, gw.Format.Extension()) {
+			fpath += gw.Format.Extension()
+		}
+	} else if gw.Archive {
+		// adjust file name if tar
 		if !strings.HasSuffix(fpath, ".tar") && !strings.HasSuffix(fpath, ".tar.gz") {
 			fpath += ".tar"
 		}
 	}
 
 	// adjust file name if gz
-	if gw.Compression != gzip.NoCompression {
+	if gw.Format == nil && gw.Compression != gzip.NoCompression {
 		if !strings.HasSuffix(fpath, ".gz") {
 			fpath += ".gz"
 		}
 	}
+	return gw.writeFile(r, fpath)
+}
+
+// writeFile write the content of r to the file fpath
+func (gw *Writer) writeFile(r io.Reader, fpath string) error {
+	fpath, err := gw.resolve(fpath)
+	if err != nil {
+		return err
+	}
+
+	// create temporary file next to the target, so rename stays on one file system
+	file, err := createTemp(fpath)
+	if err != nil {
+		return err
+	}
+	tmp := file.Name()
+	defer os.Remove(tmp) // no-op after rename
+
+	if _, err = io.Copy(file, r); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if gw.Sync {
+		if err = file.Sync(); err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	// a replaced file keeps its permissions
+	if info, err := os.Lstat(fpath); err == nil && info.Mode().IsRegular() && gw.Conflict != ConflictRename {
+		if err = os.Chmod(tmp, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	if gw.Conflict == ConflictMerge || gw.Conflict == ConflictSkipUnchanged {
+		if same, err := sameFile(tmp, fpath); err != nil || same {
+			return err
+		}
+	}
+	if err = gw.commit(tmp, fpath); err != nil {
+		return err
+	}
+	return gw.syncDir(filepath.Dir(fpath))
+}
+
+// createTemp create a hidden temporary file next to fpath with the permissions of
+// a new file, 0666 less the umask, unlike os.CreateTemp which uses 0600
+func createTemp(fpath string) (*os.File, error) {
+	prefix, suffix, _ := strings.Cut(tempPattern(fpath), "*")
+	random := make([]byte, 8)
+	for i := 0; ; i++ {
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		name := filepath.Join(filepath.Dir(fpath), prefix+hex.EncodeToString(random)+suffix)
+		file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if errors.Is(err, fs.ErrExist) && i < 100 {
+			continue
+		}
+		return file, err
+	}
+}
+
+// commit move tmp to fpath according to the conflict policy
+func (gw *Writer) commit(tmp, fpath string) error {
+	switch gw.Conflict {
+	case ConflictRename:
+		return renameFree(tmp, fpath)
+	case ConflictMerge:
+		// the file is not downloaded into the directory as it has no name of its own
+		if info, err := os.Lstat(fpath); err == nil && info.IsDir() {
+			if tmpInfo, err := os.Lstat(tmp); err == nil && !tmpInfo.IsDir() {
+				return fmt.Errorf("%w: %s is a directory", ErrOutputExists, fpath)
+			}
+		}
+	}
+	return replacePath(tmp, fpath)
+}
+
+func (gw *Writer) writeExtracted(ctx context.Context, r io.Reader, fpath string) error {
+	fpath, err := gw.resolve(fpath)
+	if err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp(filepath.Dir(fpath), tempPattern(fpath))
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// the root of the tar is extracted to tmp, a file or a directory.
+	// if the target is an existing directory, extract as into an existing
+	// directory and merge afterwards, as the extractor would do in place
+	tmp := filepath.Join(tmpDir, filepath.Base(fpath))
+	info, err := os.Stat(fpath)
+	merge := err == nil && info.IsDir() && gw.Conflict == ConflictMerge
+	if merge {
+		if err = os.Mkdir(tmp, 0755); err != nil {
+			return err
+		}
+	}
+	extractor := &extractor{
+		ctx:        ctx,
+		sink:       &dirSink{dir: tmpDir},
+		root:       filepath.Base(tmp),
+		intoDir:    merge,
+		ignoreMeta: gw.IgnoreMetadata,
+		policy:     gw.Extract,
+	}
+	if err = extractor.Extract(r); err != nil {
+		return err
+	}
+	if gw.Sync {
+		if err = syncTree(tmp); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case merge:
+		err = mergeTree(tmp, fpath)
+	case gw.Conflict == ConflictMerge:
+		if same, serr := sameFile(tmp, fpath); serr != nil || same {
+			return serr
+		}
+		err = gw.commit(tmp, fpath)
+	case gw.Conflict == ConflictSkipUnchanged:
+		if same, serr := sameTree(tmp, fpath); serr != nil || same {
+			return serr
+		}
+		err = gw.commit(tmp, fpath)
+	default:
+		err = gw.commit(tmp, fpath)
+	}
+	if err != nil {
+		return err
+	}
+	return gw.syncDir(filepath.Dir(fpath))
+}
+
+// resolve the path to write to according to the conflict policy,
+// checked before anything is downloaded
+func (gw *Writer) resolve(fpath string) (string, error) {
+	if gw.Conflict == ConflictFail {
+		if _, err := os.Lstat(fpath); err == nil {
+			return "", fmt.Errorf("%w: %s", ErrOutputExists, fpath)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+	}
+	return fpath, nil
+}
+
+// renameFree move src to fpath if it does not exist, otherwise to the first free
+// "name (n).ext". the name is reserved by creating it exclusively, so concurrent
+// writers never share a name: an empty file that the rename replaces, or a directory
+// the entries of src are moved into
+func renameFree(src, fpath string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	dir, base := filepath.Split(fpath)
+	stem, ext := splitExt(base)
+	candidate := fpath
+	for n := 1; n <= maxRename; n++ {
+		if info.IsDir() {
+			err = os.Mkdir(candidate, 0700)
+		} else {
+			var f *os.File
+			if f, err = os.OpenFile(candidate, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600); err == nil {
+				err = f.Close()
+			}
+		}
+		if err == nil {
+			if info.IsDir() {
+				err = moveInto(src, candidate, info)
+			} else {
+				err = os.Rename(src, candidate)
+			}
+			if err != nil {
+				_ = os.RemoveAll(candidate)
+			}
+			return err
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+		candidate = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", stem, n, ext))
+	}
+	return fmt.Errorf("%w: no free name for %s", ErrOutputExists, fpath)
+}
 
-	// create file
-	file, err := os.Create(fpath)
+// moveInto move the entries of the directory src into the empty directory dst,
+// and give dst the mode and mtime of src
+func moveInto(src, dst string, info fs.FileInfo) error {
+	entries, err := os.ReadDir(src)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	for _, e := range entries {
+		if err = os.Rename(filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())); err != nil {
+			return err
+		}
+	}
+	if err = os.Chmod(dst, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+// splitExt split name and extension, keeping ".tar" with the compression
+// suffix, eg: "data.tar.gz" is "data" and ".tar.gz"
+func splitExt(base string) (string, string) {
+	ext := filepath.Ext(base)
+	if ext == base {
+		// hidden file without extension, eg: ".profile"
+		return base, ""
+	}
+	stem := strings.TrimSuffix(base, ext)
+	if inner := filepath.Ext(stem); inner == ".tar" && inner != stem {
+		return strings.TrimSuffix(stem, inner), inner + ext
+	}
+	return stem, ext
+}
+
+// replacePath move src to dst, an existing dst is removed.
+// an existing directory is moved aside first and restored if the rename fails
+func replacePath(src, dst string) error {
+	info, err := os.Lstat(dst)
+	if err != nil || !info.IsDir() {
+		// renaming over a file is atomic
+		return os.Rename(src, dst)
+	}
+	old := src + ".old"
+	if err = os.Rename(dst, old); err != nil {
+		return err
+	}
+	if err = os.Rename(src, dst); err != nil {
+		_ = os.Rename(old, dst)
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+func (gw *Writer) syncDir(dir string) error {
+	if !gw.Sync {
+		return nil
+	}
+	return syncPath(dir)
+}
+
+// tempPattern hidden temporary name next to fpath
+func tempPattern(fpath string) string {
+	return "." + filepath.Base(fpath) + ".titan-tmp-*"
+}
 
-	_, err = io.Copy(file, r)
+func syncPath(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	_ = f.Close()
 	return err
 }
 
-func (gw *Writer) writeExtracted(r io.Reader, fpath string) error {
-	extractor := &tar.Extractor{Path: fpath, Progress: nil}
-	return extractor.Extract(r)
+// syncTree fsync every regular file and directory under root
+func syncTree(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		return syncPath(path)
+	})
+}
+
+// mergeTree move the entries of src into the existing directory dst, entry by entry,
+// files in dst are replaced if their content differs. symlinks in dst are replaced,
+// never followed, and a directory in dst is never replaced by a file
+func mergeTree(src, dst string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		from := filepath.Join(src, e.Name())
+		to := filepath.Join(dst, e.Name())
+		info, err := os.Lstat(to)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		existingDir := err == nil && info.IsDir()
+		if e.IsDir() {
+			if existingDir {
+				if err = mergeTree(from, to); err != nil {
+					return err
+				}
+				continue
+			}
+		} else if existingDir {
+			return fmt.Errorf("%w: %s is a directory", ErrOutputExists, to)
+		} else if same, err := sameFile(from, to); err != nil {
+			return err
+		} else if same {
+			continue
+		}
+		if err = os.RemoveAll(to); err != nil {
+			return err
+		}
+		if err = os.Rename(from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sameFile whether a and b are regular files with the same content,
+// false if b does not exist
+func sameFile(a, b string) (bool, error) {
+	ai, err := os.Lstat(a)
+	if err != nil {
+		return false, err
+	}
+	bi, err := os.Lstat(b)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !ai.Mode().IsRegular() || !bi.Mode().IsRegular() || ai.Size() != bi.Size() {
+		return false, nil
+	}
+
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	bufA := make([]byte, 32*1024)
+	bufB := make([]byte, 32*1024)
+	for {
+		n, errA := io.ReadFull(fa, bufA)
+		_, errB := io.ReadFull(fb, bufB[:n])
+		if errB != nil && errB != io.ErrUnexpectedEOF {
+			return false, errB
+		}
+		if !bytes.Equal(bufA[:n], bufB[:n]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return true, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+	}
+}
+
+// sameTree whether a and b hold the same files, directories and symlinks
+func sameTree(a, b string) (bool, error) {
+	ai, err := os.Lstat(a)
+	if err != nil {
+		return false, err
+	}
+	bi, err := os.Lstat(b)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if ai.Mode().Type() != bi.Mode().Type() {
+		return false, nil
+	}
+	switch {
+	case ai.Mode().Type()&fs.ModeSymlink != 0:
+		ta, err := os.Readlink(a)
+		if err != nil {
+			return false, err
+		}
+		tb, err := os.Readlink(b)
+		return ta == tb, err
+	case !ai.IsDir():
+		return sameFile(a, b)
+	}
+
+	ea, err := os.ReadDir(a)
+	if err != nil {
+		return false, err
+	}
+	eb, err := os.ReadDir(b)
+	if err != nil {
+		return false, err
+	}
+	if len(ea) != len(eb) {
+		return false, nil
+	}
+	for i := range ea {
+		// ReadDir sorts by name
+		if ea[i].Name() != eb[i].Name() {
+			return false, nil
+		}
+		same, err := sameTree(filepath.Join(a, ea[i].Name()), filepath.Join(b, eb[i].Name()))
+		if err != nil || !same {
+			return false, err
+		}
+	}
+	return true, nil
 }
@@ -0,0 +1,340 @@
+package titan_client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	files "github.com/ipfs/go-ipfs-files"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// tarOf the tar of node with root name, as produced by fileArchive
+func tarOf(t *testing.T, node files.Node, name string) []byte {
+	var buf bytes.Buffer
+	w, err := files.NewTarWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.WriteFile(node, name); err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Close()
+	return buf.Bytes()
+}
+
+func testDirectory() files.Node {
+	return files.NewMapDirectory(map[string]files.Node{
+		"a.txt": files.NewBytesFile([]byte("titan a")),
+		"sub": files.NewMapDirectory(map[string]files.Node{
+			"b.txt": files.NewBytesFile([]byte("titan b")),
+		}),
+	})
+}
+
+func TestWriterAtomic(t *testing.T) {
+	dir := t.TempDir()
+	data := tarOf(t, testDirectory(), "root")
+
+	out := filepath.Join(dir, "out")
+	ow := Writer{Compression: gzip.NoCompression, Sync: true}
+	if err := ow.Write(bytes.NewReader(data), out); err != nil {
+		t.Error(err)
+		return
+	}
+	b, err := os.ReadFile(filepath.Join(out, "sub", "b.txt"))
+	if err != nil || string(b) != "titan b" {
+		t.Errorf("unexpected content %q, %v", b, err)
+		return
+	}
+
+	// a broken download leaves nothing behind
+	failed := filepath.Join(dir, "failed")
+	broken := io.MultiReader(bytes.NewReader(data[:len(data)/2]), &errReader{})
+	if err = ow.Write(broken, failed); err == nil {
+		t.Error("expected error")
+		return
+	}
+	archive := Writer{Archive: true, Compression: gzip.NoCompression}
+	broken = io.MultiReader(bytes.NewReader(data[:len(data)/2]), &errReader{})
+	if err = archive.Write(broken, failed); err == nil {
+		t.Error("expected error")
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(entries) != 1 || entries[0].Name() != "out" {
+		for _, v := range entries {
+			t.Log(v.Name())
+		}
+		t.Error("partial output left behind")
+	}
+}
+
+type errReader struct{}
+
+func (e *errReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestWriterConflict(t *testing.T) {
+	dir := t.TempDir()
+	data := tarOf(t, testDirectory(), "root")
+	out := filepath.Join(dir, "out")
+	if err := (&Writer{}).Write(bytes.NewReader(data), out); err != nil {
+		t.Error(err)
+		return
+	}
+	extra := filepath.Join(out, "extra.txt")
+	if err := os.WriteFile(extra, []byte("mine"), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+	unchanged := filepath.Join(out, "a.txt")
+	before, _ := os.Stat(unchanged)
+	if err := os.WriteFile(filepath.Join(out, "sub", "b.txt"), []byte("changed"), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// merge rewrites the changed file only and keeps extra files
+	if err := (&Writer{Conflict: ConflictMerge}).Write(bytes.NewReader(data), out); err != nil {
+		t.Error(err)
+		return
+	}
+	if b, _ := os.ReadFile(filepath.Join(out, "sub", "b.txt")); string(b) != "titan b" {
+		t.Errorf("changed file not rewritten: %q", b)
+	}
+	if after, _ := os.Stat(unchanged); !os.SameFile(before, after) {
+		t.Error("unchanged file rewritten")
+	}
+	if _, err := os.Stat(extra); err != nil {
+		t.Error("extra file removed")
+	}
+
+	// overwrite replaces the output, skip keeps it if identical
+	if err := (&Writer{Conflict: ConflictOverwrite}).Write(bytes.NewReader(data), out); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := os.Stat(extra); err == nil {
+		t.Error("output not replaced")
+	}
+	before, _ = os.Stat(unchanged)
+	if err := (&Writer{Conflict: ConflictSkipUnchanged}).Write(bytes.NewReader(data), out); err != nil {
+		t.Error(err)
+		return
+	}
+	if after, _ := os.Stat(unchanged); !os.SameFile(before, after) {
+		t.Error("identical output rewritten")
+	}
+
+	err := (&Writer{Conflict: ConflictFail}).Write(bytes.NewReader(data), out)
+	if !errors.Is(err, ErrOutputExists) {
+		t.Errorf("expected ErrOutputExists, got %v", err)
+	}
+
+	archive := Writer{Archive: true, Compression: gzip.BestSpeed, Conflict: ConflictRename}
+	for i := 0; i < 2; i++ {
+		if err = archive.Write(bytes.NewReader(data), filepath.Join(dir, "data.tar.gz")); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	for _, name := range []string{"data.tar.gz", "data (1).tar.gz"} {
+		if _, err = os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestSplitExt(t *testing.T) {
+	for base, want := range map[string][2]string{
+		"data.tar.gz": {"data", ".tar.gz"},
+		"titan.txt":   {"titan", ".txt"},
+		"dir":         {"dir", ""},
+		".profile":    {".profile", ""},
+		".tar.gz":     {".tar", ".gz"},
+	} {
+		stem, ext := splitExt(base)
+		if stem != want[0] || ext != want[1] {
+			t.Errorf("splitExt(%q) = %q, %q", base, stem, ext)
+		}
+	}
+}
+
+func TestWriterRaw(t *testing.T) {
+	dir := t.TempDir()
+	reader, err := fileArchive(files.NewBytesFile([]byte("titan raw")), "raw", false, gzip.BestSpeed, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	gzr, err := gzip.NewReader(reader)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	out := filepath.Join(dir, "titan.txt")
+	ow := Writer{Archive: true, Compression: gzip.BestSpeed, Raw: true}
+	if err = ow.Write(gzr, out); err != nil {
+		t.Error(err)
+		return
+	}
+	b, err := os.ReadFile(out)
+	if err != nil || string(b) != "titan raw" {
+		t.Errorf("unexpected content %q, %v", b, err)
+	}
+
+	if _, err = fileArchive(testDirectory(), "root", false, gzip.BestSpeed, nil); !errors.Is(err, ErrIsDirectory) {
+		t.Errorf("expected ErrIsDirectory, got %v", err)
+	}
+}
+
+func TestWriterMode(t *testing.T) {
+	dir := t.TempDir()
+	// the mode of a new file under the umask of the process
+	probe := filepath.Join(dir, "probe")
+	f, err := os.OpenFile(probe, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_ = f.Close()
+	want, _ := os.Stat(probe)
+
+	out := filepath.Join(dir, "titan.txt")
+	raw := Writer{Raw: true, Conflict: ConflictOverwrite}
+	if err = raw.Write(bytes.NewReader([]byte("titan")), out); err != nil {
+		t.Error(err)
+		return
+	}
+	if info, err := os.Stat(out); err != nil || info.Mode().Perm() != want.Mode().Perm() {
+		t.Errorf("new file mode %v, want %v", info.Mode(), want.Mode())
+	}
+
+	// a replaced file keeps its mode
+	if err = os.Chmod(out, 0600); err != nil {
+		t.Error(err)
+		return
+	}
+	if err = raw.Write(bytes.NewReader([]byte("titan 2")), out); err != nil {
+		t.Error(err)
+		return
+	}
+	if info, err := os.Stat(out); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("replaced file mode %v", info.Mode())
+	}
+}
+
+func TestWriterMergeSafety(t *testing.T) {
+	dir := t.TempDir()
+	data := tarOf(t, testDirectory(), "root")
+	out := filepath.Join(dir, "out")
+	outside := filepath.Join(dir, "outside")
+	if err := os.MkdirAll(out, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// a symlink in the output is replaced, not followed
+	if err := os.Symlink(outside, filepath.Join(out, "sub")); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := (&Writer{Conflict: ConflictMerge}).Write(bytes.NewReader(data), out); err != nil {
+		t.Error(err)
+		return
+	}
+	if info, err := os.Lstat(filepath.Join(out, "sub")); err != nil || !info.IsDir() {
+		t.Errorf("symlink not replaced: %v %v", info, err)
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Errorf("written through the symlink: %v", entries)
+	}
+
+	// a directory is not replaced by a file unless overwritten
+	if err := os.Remove(filepath.Join(out, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(out, "a.txt"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Writer{Conflict: ConflictMerge}).Write(bytes.NewReader(data), out); !errors.Is(err, ErrOutputExists) {
+		t.Errorf("expected ErrOutputExists, got %v", err)
+	}
+	raw := filepath.Join(dir, "raw")
+	if err := os.Mkdir(raw, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Writer{Raw: true, Conflict: ConflictMerge}).Write(bytes.NewReader(data), raw); !errors.Is(err, ErrOutputExists) {
+		t.Errorf("expected ErrOutputExists, got %v", err)
+	}
+	if err := (&Writer{Conflict: ConflictOverwrite}).Write(bytes.NewReader(data), out); err != nil {
+		t.Error(err)
+		return
+	}
+	if b, err := os.ReadFile(filepath.Join(out, "a.txt")); err != nil || string(b) != "titan a" {
+		t.Errorf("unexpected content %q, %v", b, err)
+	}
+}
+
+func TestWriterRenameConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	data := tarOf(t, testDirectory(), "root")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := (&Writer{Raw: true, Conflict: ConflictRename}).Write(bytes.NewReader([]byte("titan")), filepath.Join(dir, "file.txt")); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := (&Writer{Conflict: ConflictRename}).Write(bytes.NewReader(data), filepath.Join(dir, "dir")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < 8; i++ {
+		file, name := "file.txt", "dir"
+		if i > 0 {
+			file, name = fmt.Sprintf("file (%d).txt", i), fmt.Sprintf("dir (%d)", i)
+		}
+		if b, err := os.ReadFile(filepath.Join(dir, file)); err != nil || string(b) != "titan" {
+			t.Errorf("%s: %q %v", file, b, err)
+		}
+		if b, err := os.ReadFile(filepath.Join(dir, name, "sub", "b.txt")); err != nil || string(b) != "titan b" {
+			t.Errorf("%s: %q %v", name, b, err)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 16 {
+		t.Errorf("expected 16 outputs, got %d", len(entries))
+	}
+}
+
+func TestWriterContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	out := filepath.Join(t.TempDir(), "out")
+	if err := (&Writer{}).WriteContext(ctx, bytes.NewReader(tarOf(t, testDirectory(), "root")), out); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if _, err := os.Lstat(out); err == nil {
+		t.Error("output of a cancelled write")
+	}
+}
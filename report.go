@@ -0,0 +1,230 @@
+package titan_client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/ipfs/go-cid"
+	"github.com/timtide/titan-client/util"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxReportFailures the failures kept in a report, the others are only counted
+const maxReportFailures = 100
+
+// Report a machine-readable summary of one download, filled while the download runs.
+// the callbacks are sent asynchronously and may still update it after Download returns,
+// read it through Snapshot or WriteJSON. a report reused for another download is reset
+type Report struct {
+	mu sync.Mutex
+
+	RootCid     string    `json:"root_cid"`
+	OutPath     string    `json:"out_path,omitempty"`
+	Start       time.Time `json:"start"`
+	DurationMs  int64     `json:"duration_ms"`
+	TotalBytes  int64     `json:"total_bytes"`
+	TotalBlocks int       `json:"total_blocks"`
+	// by source, edge, gateway or cache
+	Sources map[string]*ReportTraffic `json:"sources"`
+	// by edge node url or gateway address
+	Nodes map[string]*ReportTraffic `json:"nodes"`
+	// node failures that were retried on another node or the gateway
+	Retries int `json:"retries"`
+	// the first maxReportFailures node failures
+	Failures []ReportFailure `json:"failures,omitempty"`
+	// node failures not kept in Failures
+	FailuresDropped  int           `json:"failures_dropped,omitempty"`
+	GatewayFallbacks int           `json:"gateway_fallbacks"`
+	Locator          ReportLocator `json:"locator"`
+	// callbacks are sent asynchronously, the last ones may arrive after Download returns
+	Callbacks ReportCallbackStat `json:"callbacks"`
+	Error     string             `json:"error,omitempty"`
+}
+
+// ReportTraffic the blocks and bytes of a source or node
+type ReportTraffic struct {
+	Blocks int   `json:"blocks"`
+	Bytes  int64 `json:"bytes"`
+	// sum of request durations
+	DurationMs int64 `json:"duration_ms"`
+	Failures   int   `json:"failures"`
+}
+
+// ReportFailure a failed block request to an edge node
+type ReportFailure struct {
+	Cid   string `json:"cid"`
+	Node  string `json:"node"`
+	Error string `json:"error"`
+}
+
+// ReportLocator the download info lookup
+type ReportLocator struct {
+	Nodes      int    `json:"nodes"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// ReportCallbackStat the download results reported to locator
+type ReportCallbackStat struct {
+	Sent   int `json:"sent"`
+	Failed int `json:"failed"`
+}
+
+var _ util.Observer = (*Report)(nil)
+
+func (r *Report) begin(root cid.Cid, outPath string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.RootCid = root.String()
+	r.OutPath = outPath
+	r.Start = time.Now()
+	r.DurationMs = 0
+	r.TotalBytes = 0
+	r.TotalBlocks = 0
+	r.Sources = nil
+	r.Nodes = nil
+	r.Retries = 0
+	r.Failures = nil
+	r.FailuresDropped = 0
+	r.GatewayFallbacks = 0
+	r.Locator = ReportLocator{}
+	r.Callbacks = ReportCallbackStat{}
+	r.Error = ""
+}
+
+// Snapshot a copy of the report, consistent while callbacks still arrive
+func (r *Report) Snapshot() *Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &Report{
+		RootCid:          r.RootCid,
+		OutPath:          r.OutPath,
+		Start:            r.Start,
+		DurationMs:       r.DurationMs,
+		TotalBytes:       r.TotalBytes,
+		TotalBlocks:      r.TotalBlocks,
+		Sources:          copyTraffic(r.Sources),
+		Nodes:            copyTraffic(r.Nodes),
+		Retries:          r.Retries,
+		Failures:         append([]ReportFailure(nil), r.Failures...),
+		FailuresDropped:  r.FailuresDropped,
+		GatewayFallbacks: r.GatewayFallbacks,
+		Locator:          r.Locator,
+		Callbacks:        r.Callbacks,
+		Error:            r.Error,
+	}
+}
+
+func copyTraffic(m map[string]*ReportTraffic) map[string]*ReportTraffic {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]*ReportTraffic, len(m))
+	for k, v := range m {
+		t := *v
+		c[k] = &t
+	}
+	return c
+}
+
+func (r *Report) traffic(m *map[string]*ReportTraffic, key string) *ReportTraffic {
+	if *m == nil {
+		*m = make(map[string]*ReportTraffic)
+	}
+	t, ok := (*m)[key]
+	if !ok {
+		t = &ReportTraffic{}
+		(*m)[key] = t
+	}
+	return t
+}
+
+func (r *Report) LocatorLookupDone(root cid.Cid, nodes int, duration time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Locator = ReportLocator{Nodes: nodes, DurationMs: duration.Milliseconds()}
+	if err != nil {
+		r.Locator.Error = err.Error()
+	}
+}
+
+func (r *Report) BlockFetched(c cid.Cid, size int, source string, node string, duration time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.TotalBlocks++
+	r.TotalBytes += int64(size)
+	s := r.traffic(&r.Sources, source)
+	s.Blocks++
+	s.Bytes += int64(size)
+	s.DurationMs += duration.Milliseconds()
+	if node != "" {
+		n := r.traffic(&r.Nodes, node)
+		n.Blocks++
+		n.Bytes += int64(size)
+		n.DurationMs += duration.Milliseconds()
+	}
+}
+
+func (r *Report) NodeFailed(c cid.Cid, node string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.traffic(&r.Nodes, node).Failures++
+	if len(r.Failures) < maxReportFailures {
+		r.Failures = append(r.Failures, ReportFailure{Cid: c.String(), Node: node, Error: err.Error()})
+	} else {
+		r.FailuresDropped++
+	}
+	// a cancelled request is not retried
+	if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		r.Retries++
+	}
+}
+
+func (r *Report) GatewayFallback(c cid.Cid, gateway string, reason error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.GatewayFallbacks++
+}
+
+func (r *Report) CallbackSent(c cid.Cid, sn int64, downloadSuccess bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Callbacks.Sent++
+	if err != nil {
+		r.Callbacks.Failed++
+	}
+}
+
+func (r *Report) DownloadComplete(root cid.Cid, outPath string, duration time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.DurationMs = duration.Milliseconds()
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// WriteJSON write the report as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteJSONFile write the report as indented JSON to path
+func (r *Report) WriteJSONFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = r.WriteJSON(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,128 @@
+package titan_client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ipfs/go-cid"
+	"github.com/timtide/titan-client/util"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+	c, err := cid.Decode("QmUbaDBz6YKn3dVzoKrLDyupMmyWk5am2QSdgfKsU1RN3N")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	r := &Report{}
+	r.begin(c, "./titan.mp4")
+	r.LocatorLookupDone(c, 2, 10*time.Millisecond, nil)
+	r.BlockFetched(c, 100, util.SourceEdge, "http://node-a", 5*time.Millisecond)
+	r.NodeFailed(c, "http://node-b", fmt.Errorf("%w : test", util.ErrHashMismatch))
+	r.BlockFetched(c, 50, util.SourceGateway, "https://ipfs.io/ipfs/", 20*time.Millisecond)
+	r.GatewayFallback(c, "https://ipfs.io/ipfs/", nil)
+	r.BlockFetched(c, 100, util.SourceCache, "", 0)
+	r.CallbackSent(c, 1, true, nil)
+	r.DownloadComplete(c, "./titan.mp4", time.Second, nil)
+
+	var buf bytes.Buffer
+	if err = r.WriteJSON(&buf); err != nil {
+		t.Error(err)
+		return
+	}
+	t.Log(buf.String())
+
+	var decoded Report
+	if err = json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Error(err)
+		return
+	}
+	if decoded.TotalBytes != 250 || decoded.TotalBlocks != 3 || decoded.Retries != 1 || decoded.GatewayFallbacks != 1 {
+		t.Errorf("unexpected report %s", buf.String())
+		return
+	}
+	if decoded.Sources[util.SourceEdge].Bytes != 100 || decoded.Nodes["http://node-b"].Failures != 1 {
+		t.Errorf("unexpected traffic %+v %+v", decoded.Sources, decoded.Nodes)
+	}
+}
+
+func TestReportReuse(t *testing.T) {
+	c, _ := cid.Decode("QmUbaDBz6YKn3dVzoKrLDyupMmyWk5am2QSdgfKsU1RN3N")
+	r := &Report{}
+	r.begin(c, "a")
+	for i := 0; i < maxReportFailures+10; i++ {
+		r.NodeFailed(c, "http://node-a", errors.New("timeout"))
+	}
+	r.NodeFailed(c, "http://node-a", context.Canceled)
+	r.BlockFetched(c, 100, util.SourceGateway, "https://ipfs.io/ipfs/", time.Millisecond)
+	r.DownloadComplete(c, "a", time.Second, errors.New("fail"))
+
+	snapshot := r.Snapshot()
+	if len(snapshot.Failures) != maxReportFailures || snapshot.FailuresDropped != 11 || snapshot.Retries != maxReportFailures+10 {
+		t.Errorf("unexpected failures %d, dropped %d, retries %d", len(snapshot.Failures), snapshot.FailuresDropped, snapshot.Retries)
+	}
+	// the snapshot does not change with the report
+	r.BlockFetched(c, 100, util.SourceGateway, "https://ipfs.io/ipfs/", time.Millisecond)
+	if snapshot.TotalBlocks != 1 || snapshot.Sources[util.SourceGateway].Blocks != 1 {
+		t.Errorf("snapshot changed %+v", snapshot.Sources[util.SourceGateway])
+	}
+
+	// a new download starts from scratch
+	r.begin(c, "b")
+	snapshot = r.Snapshot()
+	if snapshot.OutPath != "b" || snapshot.TotalBlocks != 0 || snapshot.Retries != 0 || len(snapshot.Failures) != 0 ||
+		snapshot.FailuresDropped != 0 || snapshot.Nodes != nil || snapshot.Error != "" {
+		t.Errorf("report not reset %+v", snapshot)
+	}
+}
+
+func TestReportGetReader(t *testing.T) {
+	// a reader completes the report when it is drained or closed
+	var completed []error
+	reader := &completeReadCloser{ReadCloser: io.NopCloser(strings.NewReader("titan")), done: func(err error) {
+		completed = append(completed, err)
+	}}
+	if data, err := io.ReadAll(reader); err != nil || string(data) != "titan" {
+		t.Errorf("read %q %v", data, err)
+	}
+	_ = reader.Close()
+	if len(completed) != 1 || completed[0] != nil {
+		t.Errorf("unexpected completions %v", completed)
+	}
+
+	// a failed GetReader completes and writes the report immediately
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	c, _ := cid.Decode("QmYLniRF9EL5CCV5hY5z9KEYqnRdhvjpCGZgvNxL8JCc2E")
+	report := &Report{}
+	path := filepath.Join(t.TempDir(), "report.json")
+	downloader := NewDownloader(WithLocatorAddressOption(server.URL), WithCustomGatewayAddressOption(server.URL), WithHTTPClientOption(server.Client()))
+	if _, err := downloader.(OptionDownloader).GetReaderWithOptions(context.Background(), c, false, 0, WithReportOption(report), WithReportFileOption(path)); err == nil {
+		t.Error("expected GetReader to fail")
+		return
+	}
+	if snapshot := report.Snapshot(); snapshot.Error == "" || snapshot.RootCid != c.String() {
+		t.Errorf("report not completed %+v", snapshot)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var decoded Report
+	if err = json.Unmarshal(data, &decoded); err != nil || decoded.Error == "" {
+		t.Errorf("unexpected report file %s %v", data, err)
+	}
+}
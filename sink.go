@@ -0,0 +1,473 @@
+package titan_client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	gopath "path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink a writable file system receiving a download file by file, see DownloadToSink.
+// names are slash separated and relative to the root of the sink, as io/fs.
+// a sink may implement SymlinkSink and MetaSink, and fs.FS to copy the targets of SymlinkFollow
+type Sink interface {
+	// MkdirAll create the directory name and its parents
+	MkdirAll(name string) error
+	// Create the file name of size bytes, replacing an existing one.
+	// it is complete once Close returns nil. a writer with CloseWithError(error) error
+	// is closed with it instead if the copy fails, and drops the partial file
+	Create(ctx context.Context, name string, size int64) (io.WriteCloser, error)
+}
+
+// SymlinkSink a Sink able to store symlinks, they are dropped by other sinks
+type SymlinkSink interface {
+	Sink
+	Symlink(target, name string) error
+}
+
+// MetaSink a Sink able to store the mode and mtime of files and directories
+type MetaSink interface {
+	Sink
+	// SetMeta set the permissions of name, and its mtime unless zero
+	SetMeta(name string, mode fs.FileMode, mtime time.Time) error
+}
+
+// NewDirSink a Sink writing to the local directory dir
+func NewDirSink(dir string) Sink {
+	return &dirSink{dir: dir}
+}
+
+type dirSink struct {
+	dir string
+}
+
+var _ SymlinkSink = (*dirSink)(nil)
+var _ MetaSink = (*dirSink)(nil)
+var _ fs.FS = (*dirSink)(nil)
+
+func (s *dirSink) path(name string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(name))
+}
+
+func (s *dirSink) MkdirAll(name string) error {
+	p := s.path(name)
+	if err := os.MkdirAll(p, 0755); err != nil {
+		return err
+	}
+	info, err := os.Lstat(p)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("cannot create directory %s", p)
+	}
+	return nil
+}
+
+func (s *dirSink) Create(ctx context.Context, name string, size int64) (io.WriteCloser, error) {
+	p := s.path(name)
+	// overwrite files, symlinks and empty directories
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &dirFile{File: f}, nil
+}
+
+// dirFile a file of dirSink, removed if the copy fails
+type dirFile struct {
+	*os.File
+}
+
+// CloseWithError close and remove the partial file
+func (f *dirFile) CloseWithError(err error) error {
+	_ = f.File.Close()
+	return os.Remove(f.Name())
+}
+
+func (s *dirSink) Symlink(target, name string) error {
+	p := s.path(name)
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Symlink(target, p)
+}
+
+// SetMeta the permissions are masked by the umask as those of a new file
+func (s *dirSink) SetMeta(name string, mode fs.FileMode, mtime time.Time) error {
+	p := s.path(name)
+	if err := os.Chmod(p, mode&^umask); err != nil {
+		return err
+	}
+	if mtime.IsZero() {
+		return nil
+	}
+	return os.Chtimes(p, mtime, mtime)
+}
+
+func (s *dirSink) Open(name string) (fs.File, error) {
+	return os.DirFS(s.dir).Open(name)
+}
+
+// MemSink a Sink keeping the download in memory, eg: for tests or small directories
+type MemSink struct {
+	mu    sync.Mutex
+	files memFS
+}
+
+var _ SymlinkSink = (*MemSink)(nil)
+var _ MetaSink = (*MemSink)(nil)
+var _ fs.FS = (*MemSink)(nil)
+
+func NewMemSink() *MemSink {
+	return &MemSink{files: make(memFS)}
+}
+
+func (m *MemSink) MkdirAll(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if name == "." || name == "" {
+		return nil
+	}
+	elems := strings.Split(name, "/")
+	for i := range elems {
+		p := strings.Join(elems[:i+1], "/")
+		if f, ok := m.files[p]; ok {
+			if !f.mode.IsDir() {
+				return fmt.Errorf("cannot create directory %s", p)
+			}
+			continue
+		}
+		m.files[p] = &memEntry{mode: fs.ModeDir | defaultDirMode, modTime: time.Now()}
+	}
+	return nil
+}
+
+func (m *MemSink) Create(ctx context.Context, name string, size int64) (io.WriteCloser, error) {
+	return &memFile{sink: m, name: name}, nil
+}
+
+func (m *MemSink) Symlink(target, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[name] = &memEntry{data: []byte(target), mode: fs.ModeSymlink | 0777, modTime: time.Now()}
+	return nil
+}
+
+func (m *MemSink) SetMeta(name string, mode fs.FileMode, mtime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[name]
+	if !ok {
+		if name == "." {
+			return nil
+		}
+		return &fs.PathError{Op: "setmeta", Path: name, Err: fs.ErrNotExist}
+	}
+	f.mode = f.mode.Type() | mode
+	if !mtime.IsZero() {
+		f.modTime = mtime
+	}
+	return nil
+}
+
+func (m *MemSink) Open(name string) (fs.File, error) {
+	return m.FS().Open(name)
+}
+
+// FS a snapshot of the files written so far. symlinks are not followed,
+// reading one returns its target
+func (m *MemSink) FS() fs.FS {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot := make(memFS, len(m.files))
+	for name, f := range m.files {
+		c := *f
+		snapshot[name] = &c
+	}
+	return snapshot
+}
+
+type memFile struct {
+	sink *MemSink
+	name string
+	buf  bytes.Buffer
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	return f.buf.Write(p)
+}
+
+func (f *memFile) Close() error {
+	f.sink.mu.Lock()
+	defer f.sink.mu.Unlock()
+	f.sink.files[f.name] = &memEntry{data: f.buf.Bytes(), mode: defaultFileMode, modTime: time.Now()}
+	return nil
+}
+
+// CloseWithError drop the file, the copy failed
+func (f *memFile) CloseWithError(err error) error {
+	f.sink.mu.Lock()
+	defer f.sink.mu.Unlock()
+	delete(f.sink.files, f.name)
+	return nil
+}
+
+// memFS the entries of a MemSink by slash separated name, the parents of an entry
+// missing from the map are directories
+type memFS map[string]*memEntry
+
+type memEntry struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (m memFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	entry, ok := m[name]
+	if ok && !entry.mode.IsDir() {
+		return &memOpenFile{info: memInfo{name: gopath.Base(name), entry: entry}, r: bytes.NewReader(entry.data)}, nil
+	}
+
+	// the entries of the directory name
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+	children := make(map[string]*memEntry)
+	for p, e := range m {
+		if !strings.HasPrefix(p, prefix) || p == name {
+			continue
+		}
+		child, rest, nested := strings.Cut(p[len(prefix):], "/")
+		if nested || rest != "" {
+			if _, ok := children[child]; !ok {
+				children[child] = nil
+			}
+			continue
+		}
+		children[child] = e
+	}
+	if !ok && len(children) == 0 && name != "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if !ok {
+		entry = &memEntry{mode: fs.ModeDir | 0555}
+	}
+	dir := &memOpenDir{info: memInfo{name: gopath.Base(name), entry: entry}}
+	for child, e := range children {
+		if e == nil {
+			// a parent missing from the map
+			if e = m[prefix+child]; e == nil {
+				e = &memEntry{mode: fs.ModeDir | 0555}
+			}
+		}
+		dir.entries = append(dir.entries, fs.FileInfoToDirEntry(memInfo{name: child, entry: e}))
+	}
+	sort.Slice(dir.entries, func(i, j int) bool {
+		return dir.entries[i].Name() < dir.entries[j].Name()
+	})
+	return dir, nil
+}
+
+type memInfo struct {
+	name  string
+	entry *memEntry
+}
+
+func (i memInfo) Name() string {
+	return i.name
+}
+
+func (i memInfo) Size() int64 {
+	return int64(len(i.entry.data))
+}
+
+func (i memInfo) Mode() fs.FileMode {
+	return i.entry.mode
+}
+
+func (i memInfo) ModTime() time.Time {
+	return i.entry.modTime
+}
+
+func (i memInfo) IsDir() bool {
+	return i.entry.mode.IsDir()
+}
+
+func (i memInfo) Sys() interface{} {
+	return nil
+}
+
+type memOpenFile struct {
+	info memInfo
+	r    *bytes.Reader
+}
+
+func (f *memOpenFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *memOpenFile) Read(p []byte) (int, error) {
+	return f.r.Read(p)
+}
+
+func (f *memOpenFile) Close() error {
+	return nil
+}
+
+func (f *memOpenFile) Seek(offset int64, whence int) (int64, error) {
+	return f.r.Seek(offset, whence)
+}
+
+func (f *memOpenFile) ReadAt(p []byte, off int64) (int, error) {
+	return f.r.ReadAt(p, off)
+}
+
+type memOpenDir struct {
+	info    memInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *memOpenDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *memOpenDir) Close() error {
+	return nil
+}
+
+func (d *memOpenDir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *memOpenDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n > 0 && len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(rest) {
+		rest = rest[:n]
+	}
+	d.offset += len(rest)
+	return rest, nil
+}
+
+// ObjectStore the object storage a download is uploaded to, eg: an adapter
+// of an S3 compatible client, see NewHTTPObjectStore
+type ObjectStore interface {
+	// PutObject upload the object key with the content of r, size is -1 if unknown.
+	// an error reading r must fail the upload
+	PutObject(ctx context.Context, key string, r io.Reader, size int64) error
+}
+
+// NewObjectStoreSink a Sink uploading every file as an object named prefix/name,
+// directories and symlinks are not stored
+func NewObjectStoreSink(store ObjectStore, prefix string) Sink {
+	return &objectSink{store: store, prefix: strings.Trim(prefix, "/")}
+}
+
+type objectSink struct {
+	store  ObjectStore
+	prefix string
+}
+
+func (s *objectSink) MkdirAll(name string) error {
+	return nil
+}
+
+func (s *objectSink) Create(ctx context.Context, name string, size int64) (io.WriteCloser, error) {
+	pr, pw := io.Pipe()
+	o := &objectWriter{PipeWriter: pw, done: make(chan error, 1)}
+	key := gopath.Join(s.prefix, name)
+	go func() {
+		err := s.store.PutObject(ctx, key, pr, size)
+		// unblock the writer if the upload stopped reading
+		_ = pr.CloseWithError(fmt.Errorf("upload %s stopped: %v", key, err))
+		o.done <- err
+	}()
+	return o, nil
+}
+
+// objectWriter streams one object, Close waits for the upload
+type objectWriter struct {
+	*io.PipeWriter
+	done chan error
+}
+
+func (o *objectWriter) Close() error {
+	_ = o.PipeWriter.Close()
+	return <-o.done
+}
+
+// CloseWithError abort the upload
+func (o *objectWriter) CloseWithError(err error) error {
+	_ = o.PipeWriter.CloseWithError(err)
+	<-o.done
+	return nil
+}
+
+// NewHTTPObjectStore an ObjectStore sending a PUT request to endpoint/key for every object,
+// eg: a MinIO or S3 bucket url accepting anonymous uploads, or an upload gateway.
+// header is added to the requests, eg: Authorization. the requests are not signed,
+// S3 authentication needs a signing RoundTripper in client, eg: AWS SigV4,
+// or an ObjectStore adapter of an S3 client
+func NewHTTPObjectStore(endpoint string, client *http.Client, header http.Header) ObjectStore {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpObjectStore{endpoint: strings.TrimRight(endpoint, "/"), client: client, header: header}
+}
+
+type httpObjectStore struct {
+	endpoint string
+	client   *http.Client
+	header   http.Header
+}
+
+func (h *httpObjectStore) PutObject(ctx context.Context, key string, r io.Reader, size int64) error {
+	elems := strings.Split(key, "/")
+	for i := range elems {
+		elems[i] = url.PathEscape(elems[i])
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, h.endpoint+"/"+strings.Join(elems, "/"), r)
+	if err != nil {
+		return err
+	}
+	for k, v := range h.header {
+		req.Header[k] = v
+	}
+	if size >= 0 {
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("put object %s: %s", key, resp.Status)
+	}
+	return nil
+}
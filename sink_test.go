@@ -0,0 +1,194 @@
+package titan_client
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func sinkTar(t *testing.T) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	mtime := time.Unix(1600000000, 0)
+	for _, h := range []*tar.Header{
+		{Name: "root", Typeflag: tar.TypeDir, Mode: 0700, ModTime: mtime},
+		{Name: "root/a", Typeflag: tar.TypeDir, Mode: 0755, ModTime: mtime},
+		{Name: "root/a/b.txt", Typeflag: tar.TypeReg, Mode: 0600, ModTime: mtime, Size: 5},
+		{Name: "root/c d.txt", Typeflag: tar.TypeReg, Mode: 0644, ModTime: mtime, Size: 0},
+		{Name: "root/link", Typeflag: tar.TypeSymlink, Linkname: "a/b.txt", Mode: 0777, ModTime: mtime},
+	} {
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Size > 0 {
+			_, _ = tw.Write([]byte("titan"))
+		}
+	}
+	_ = tw.Close()
+	return buf.Bytes()
+}
+
+func TestMemSink(t *testing.T) {
+	sink := NewMemSink()
+	gw := &Writer{}
+	if err := gw.WriteSink(context.Background(), bytes.NewReader(sinkTar(t)), sink, "out"); err != nil {
+		t.Error(err)
+		return
+	}
+	mfs := sink.FS()
+	if data, err := fs.ReadFile(mfs, "out/a/b.txt"); err != nil || string(data) != "titan" {
+		t.Errorf("out/a/b.txt: %q %v", data, err)
+	}
+	if f, err := fs.Stat(mfs, "out/a/b.txt"); err != nil || f.Mode().Perm() != 0600 || !f.ModTime().Equal(time.Unix(1600000000, 0)) {
+		t.Errorf("out/a/b.txt metadata: %v %v", f, err)
+	}
+	if d, err := fs.Stat(mfs, "out"); err != nil || !d.IsDir() || d.Mode().Perm() != 0700 {
+		t.Errorf("out metadata: %v %v", d, err)
+	}
+	if l, err := fs.Stat(mfs, "out/link"); err != nil || l.Mode()&fs.ModeSymlink == 0 {
+		t.Errorf("out/link: %v %v", l, err)
+	}
+	if target, err := fs.ReadFile(mfs, "out/link"); err != nil || string(target) != "a/b.txt" {
+		t.Errorf("out/link target: %q %v", target, err)
+	}
+	if err := fstest.TestFS(mfs, "out/a/b.txt", "out/c d.txt", "out/link"); err != nil {
+		t.Error(err)
+	}
+	if _, err := mfs.Open("../out"); err == nil {
+		t.Error("expected an invalid path error")
+	}
+
+	// follow copies the target from the sink
+	sink = NewMemSink()
+	gw = &Writer{Extract: ExtractPolicy{Symlinks: SymlinkFollow}}
+	if err := gw.WriteSink(context.Background(), bytes.NewReader(sinkTar(t)), sink, ""); err != nil {
+		t.Error(err)
+		return
+	}
+	if data, err := fs.ReadFile(sink.FS(), "link"); err != nil || string(data) != "titan" {
+		t.Errorf("link: %q %v", data, err)
+	}
+
+	if err := gw.WriteSink(context.Background(), bytes.NewReader(sinkTar(t)), sink, "../out"); !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("expected ErrUnsafePath, got %v", err)
+	}
+
+	// a failed copy drops the file
+	w, err := sink.Create(context.Background(), "partial.txt", 5)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, _ = w.Write([]byte("ti"))
+	_ = w.(interface{ CloseWithError(error) error }).CloseWithError(io.ErrUnexpectedEOF)
+	if _, err = fs.Stat(sink.FS(), "partial.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected the partial file dropped, got %v", err)
+	}
+}
+
+// objectServer a MinIO style stand-in storing the PUT objects of a bucket
+type objectServer struct {
+	mu      sync.Mutex
+	objects map[string]string
+}
+
+func (s *objectServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut || !strings.HasPrefix(r.URL.Path, "/bucket/") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer titan" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.objects[strings.TrimPrefix(r.URL.Path, "/bucket/")] = string(data)
+	s.mu.Unlock()
+}
+
+func TestObjectStoreSink(t *testing.T) {
+	server := &objectServer{objects: make(map[string]string)}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	header := http.Header{"Authorization": []string{"Bearer titan"}}
+	store := NewHTTPObjectStore(ts.URL+"/bucket", ts.Client(), header)
+	gw := &Writer{}
+	if err := gw.WriteSink(context.Background(), bytes.NewReader(sinkTar(t)), NewObjectStoreSink(store, "/backup/"), "out"); err != nil {
+		t.Error(err)
+		return
+	}
+	want := map[string]string{"backup/out/a/b.txt": "titan", "backup/out/c d.txt": ""}
+	if len(server.objects) != len(want) {
+		t.Errorf("objects %v, want %v", server.objects, want)
+	}
+	for key, data := range want {
+		if got, ok := server.objects[key]; !ok || got != data {
+			t.Errorf("object %s: %q %v", key, got, ok)
+		}
+	}
+
+	// a rejected upload fails the download
+	store = NewHTTPObjectStore(ts.URL+"/bucket", ts.Client(), nil)
+	err := gw.WriteSink(context.Background(), bytes.NewReader(sinkTar(t)), NewObjectStoreSink(store, ""), "out")
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expected 403, got %v", err)
+	}
+}
+
+func TestDirSink(t *testing.T) {
+	dir := t.TempDir()
+	sink := NewDirSink(dir).(MetaSink)
+	w, err := sink.Create(context.Background(), "titan.txt", 5)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err = w.Write([]byte("titan")); err != nil {
+		t.Error(err)
+		return
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+	// the umask applies to the UnixFS mode as to a new file
+	if err = sink.SetMeta("titan.txt", 0777, time.Time{}); err != nil {
+		t.Error(err)
+		return
+	}
+	if info, err := os.Stat(filepath.Join(dir, "titan.txt")); err != nil || info.Mode().Perm() != 0777&^umask {
+		t.Errorf("mode %v, want %v", info.Mode(), 0777&^umask)
+	}
+
+	// a failed copy leaves no file
+	w, err = sink.Create(context.Background(), "partial.txt", 5)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, _ = w.Write([]byte("ti"))
+	if err = w.(interface{ CloseWithError(error) error }).CloseWithError(io.ErrUnexpectedEOF); err != nil {
+		t.Error(err)
+	}
+	if _, err = os.Lstat(filepath.Join(dir, "partial.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected the partial file removed, got %v", err)
+	}
+}
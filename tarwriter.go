@@ -0,0 +1,89 @@
+package titan_client
+
+import (
+	"archive/tar"
+	"fmt"
+	files "github.com/ipfs/go-ipfs-files"
+	"io"
+	"os"
+	gopath "path"
+	"time"
+)
+
+// writeTar write node with the root name to w as tar, as files.TarWriter,
+// with the UnixFS mode and mtime of the nodes, defaults if absent
+func writeTar(w io.Writer, node files.Node, name string) error {
+	tw := tar.NewWriter(w)
+	if err := writeTarNode(tw, node, name); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func writeTarNode(tw *tar.Writer, nd files.Node, fpath string) error {
+	meta := nodeMeta(nd)
+	switch nd := nd.(type) {
+	case *files.Symlink:
+		return tw.WriteHeader(&tar.Header{
+			Name:     fpath,
+			Linkname: nd.Target,
+			Mode:     0777,
+			ModTime:  meta.modTime(),
+			Typeflag: tar.TypeSymlink,
+		})
+	case files.File:
+		size, err := nd.Size()
+		if err != nil {
+			return err
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Name:     fpath,
+			Size:     size,
+			Mode:     meta.tarMode(defaultFileMode),
+			ModTime:  meta.modTime(),
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(tw, nd); err != nil {
+			return err
+		}
+		return tw.Flush()
+	case files.Directory:
+		err := tw.WriteHeader(&tar.Header{
+			Name:     fpath,
+			Mode:     meta.tarMode(defaultDirMode),
+			ModTime:  meta.modTime(),
+			Typeflag: tar.TypeDir,
+		})
+		if err != nil {
+			return err
+		}
+		it := nd.Entries()
+		for it.Next() {
+			if err = writeTarNode(tw, it.Node(), gopath.Join(fpath, it.Name())); err != nil {
+				return err
+			}
+		}
+		return it.Err()
+	default:
+		return fmt.Errorf("file type %T is not supported", nd)
+	}
+}
+
+// tarMode the mode of a tar header, def if absent
+func (m unixfsMeta) tarMode(def os.FileMode) int64 {
+	if m.mode == 0 {
+		return int64(def.Perm())
+	}
+	return int64(m.mode)
+}
+
+// modTime the mtime, now if absent
+func (m unixfsMeta) modTime() time.Time {
+	if m.mtime.IsZero() {
+		return time.Now().Truncate(time.Second)
+	}
+	return m.mtime
+}
@@ -0,0 +1,54 @@
+package titan_client
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"sync"
+)
+
+// spans are created with the global TracerProvider, no-op until the
+// application sets one with otel.SetTracerProvider
+var tracer = otel.Tracer("github.com/timtide/titan-client")
+
+// endSpan record the error if any and end the span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// spanReadCloser ends the span of the DAG walk when the reader is drained or closed
+type spanReadCloser struct {
+	io.ReadCloser
+	span  trace.Span
+	bytes int64
+	once  sync.Once
+}
+
+func (s *spanReadCloser) Read(p []byte) (int, error) {
+	n, err := s.ReadCloser.Read(p)
+	s.bytes += int64(n)
+	if err == io.EOF {
+		s.end(nil)
+	} else if err != nil {
+		s.end(err)
+	}
+	return n, err
+}
+
+func (s *spanReadCloser) Close() error {
+	s.end(nil)
+	return s.ReadCloser.Close()
+}
+
+func (s *spanReadCloser) end(err error) {
+	s.once.Do(func() {
+		s.span.SetAttributes(attribute.Int64("bytes", s.bytes))
+		endSpan(s.span, err)
+	})
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package titan_client
+
+import (
+	"io/fs"
+)
+
+// umask there is no umask outside unix
+var umask fs.FileMode = 0
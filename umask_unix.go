@@ -0,0 +1,17 @@
+//go:build unix
+
+package titan_client
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// umask of the process, read at init as syscall.Umask can only be read by setting it
+var umask = readUmask()
+
+func readUmask() fs.FileMode {
+	mask := syscall.Umask(0)
+	syscall.Umask(mask)
+	return fs.FileMode(mask) & fs.ModePerm
+}
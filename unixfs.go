@@ -0,0 +1,347 @@
+package titan_client
+
+import (
+	"context"
+	"errors"
+	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
+	md "github.com/ipfs/go-merkledag"
+	ft "github.com/ipfs/go-unixfs"
+	uio "github.com/ipfs/go-unixfs/io"
+	"google.golang.org/protobuf/encoding/protowire"
+	"os"
+	gopath "path"
+	"time"
+)
+
+// number of directory entries prefetched while walking, as go-unixfs/file
+const prefetchFiles = 4
+
+// fields of the UnixFS 1.5 Data message, go-unixfs does not know them yet
+const (
+	fieldMode         protowire.Number = 7
+	fieldMtime        protowire.Number = 8
+	fieldMtimeSeconds protowire.Number = 1
+	fieldMtimeNanos   protowire.Number = 2
+)
+
+// default permissions of entries without UnixFS mode
+const (
+	defaultFileMode os.FileMode = 0644
+	defaultDirMode  os.FileMode = 0755
+)
+
+// unixfsMeta the optional mode and mtime of a UnixFS node
+type unixfsMeta struct {
+	// mode permission and setuid, setgid and sticky bits, 0 if absent
+	mode uint32
+	// mtime zero if absent
+	mtime time.Time
+}
+
+// metaNode a files.Node carrying UnixFS metadata
+type metaNode interface {
+	unixfsMeta() unixfsMeta
+}
+
+// nodeMeta the metadata of nd, zero if it has none
+func nodeMeta(nd files.Node) unixfsMeta {
+	if m, ok := nd.(metaNode); ok {
+		return m.unixfsMeta()
+	}
+	return unixfsMeta{}
+}
+
+// fileMode the permissions of the entry, def if absent
+func (m unixfsMeta) fileMode(def os.FileMode) os.FileMode {
+	if m.mode == 0 {
+		return def
+	}
+	mode := os.FileMode(m.mode & 0777)
+	if m.mode&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m.mode&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m.mode&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// parseUnixfsMeta read mode and mtime from the UnixFS Data message,
+// malformed fields are ignored
+func parseUnixfsMeta(data []byte) unixfsMeta {
+	var meta unixfsMeta
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return meta
+		}
+		data = data[n:]
+		switch {
+		case num == fieldMode && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return meta
+			}
+			meta.mode = uint32(v) & 07777
+			data = data[n:]
+		case num == fieldMtime && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return meta
+			}
+			meta.mtime = parseUnixTime(v)
+			data = data[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return meta
+			}
+			data = data[n:]
+		}
+	}
+	return meta
+}
+
+// parseUnixTime the UnixTime message, seconds and fractional nanoseconds
+func parseUnixTime(data []byte) time.Time {
+	var sec int64
+	var nsec uint32
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return time.Time{}
+		}
+		data = data[n:]
+		switch {
+		case num == fieldMtimeSeconds && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return time.Time{}
+			}
+			sec = int64(v)
+			data = data[n:]
+		case num == fieldMtimeNanos && typ == protowire.Fixed32Type:
+			v, n := protowire.ConsumeFixed32(data)
+			if n < 0 {
+				return time.Time{}
+			}
+			nsec = v
+			data = data[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return time.Time{}
+			}
+			data = data[n:]
+		}
+	}
+	if nsec >= 1e9 {
+		nsec = 0
+	}
+	return time.Unix(sec, int64(nsec))
+}
+
+// dagWalker turns a UnixFS DAG into files nodes fetched lazily while they are read,
+// as go-unixfs/file, keeping the UnixFS metadata of the nodes
+type dagWalker struct {
+	ctx context.Context
+	ds  ipld.DAGService
+	// ignoreMeta drop mode and mtime of the nodes
+	ignoreMeta bool
+	// filter the entries of directories, those skipped are not fetched
+	filter *pathFilter
+}
+
+// node the files node of the root nd, a directory, file or symlink
+func (w *dagWalker) node(nd ipld.Node) (files.Node, error) {
+	return w.nodeAt(nd, "")
+}
+
+// nodeAt the files node of nd at path p below the root
+func (w *dagWalker) nodeAt(nd ipld.Node, p string) (files.Node, error) {
+	var meta unixfsMeta
+	switch dn := nd.(type) {
+	case *md.ProtoNode:
+		fsn, err := ft.FSNodeFromBytes(dn.Data())
+		if err != nil {
+			return nil, err
+		}
+		if !w.ignoreMeta {
+			meta = parseUnixfsMeta(dn.Data())
+		}
+		if fsn.IsDir() {
+			return w.directory(dn, p, meta)
+		}
+		if fsn.Type() == ft.TSymlink {
+			return files.NewLinkFile(string(fsn.Data()), nil), nil
+		}
+	case *md.RawNode:
+	default:
+		return nil, errors.New("unknown node type")
+	}
+
+	dr, err := uio.NewDagReader(w.ctx, nd, w.ds)
+	if err != nil {
+		return nil, err
+	}
+	return &dagFile{DagReader: dr, meta: meta}, nil
+}
+
+func (w *dagWalker) directory(nd *md.ProtoNode, p string, meta unixfsMeta) (files.Directory, error) {
+	dir, err := uio.NewDirectoryFromNode(w.ds, nd)
+	if err != nil {
+		return nil, err
+	}
+	size, err := nd.Size()
+	if err != nil {
+		return nil, err
+	}
+	return &dagDirectory{walker: w, dir: dir, path: p, size: int64(size), meta: meta}, nil
+}
+
+type dagFile struct {
+	uio.DagReader
+	meta unixfsMeta
+}
+
+func (f *dagFile) Size() (int64, error) {
+	return int64(f.DagReader.Size()), nil
+}
+
+func (f *dagFile) unixfsMeta() unixfsMeta {
+	return f.meta
+}
+
+type dagDirectory struct {
+	walker *dagWalker
+	dir    uio.Directory
+	// path below the root, empty for the root
+	path string
+	size int64
+	meta unixfsMeta
+}
+
+func (d *dagDirectory) Close() error {
+	return nil
+}
+
+func (d *dagDirectory) Size() (int64, error) {
+	return d.size, nil
+}
+
+func (d *dagDirectory) unixfsMeta() unixfsMeta {
+	return d.meta
+}
+
+func (d *dagDirectory) Entries() files.DirIterator {
+	ctx := d.walker.ctx
+	linkCh := make(chan *ipld.Link, prefetchFiles)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- d.dir.ForEachLink(ctx, func(link *ipld.Link) error {
+			select {
+			case linkCh <- link:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			return nil
+		})
+		close(errCh)
+		close(linkCh)
+	}()
+	return &dagIterator{walker: d.walker, path: d.path, links: linkCh, errCh: errCh}
+}
+
+type dagIterator struct {
+	walker *dagWalker
+	path   string
+	links  chan *ipld.Link
+	errCh  chan error
+
+	curName string
+	curNode files.Node
+	err     error
+}
+
+func (it *dagIterator) Name() string {
+	return it.curName
+}
+
+func (it *dagIterator) Node() files.Node {
+	return it.curNode
+}
+
+func (it *dagIterator) Err() error {
+	return it.err
+}
+
+func (it *dagIterator) Next() bool {
+	it.curNode = nil
+	filter := it.walker.filter
+	for {
+		link := it.nextLink()
+		if link == nil {
+			return false
+		}
+		// skip before fetching when the type does not matter
+		p := gopath.Join(it.path, link.Name)
+		if filter.excluded(p) {
+			continue
+		}
+		included := filter.included(p)
+		if !included && !filter.mayBeDirectory(p, link.Cid) {
+			continue
+		}
+
+		nd, err := link.GetNode(it.walker.ctx, it.walker.ds)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		node, err := it.walker.nodeAt(nd, p)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if _, ok := node.(files.Directory); !ok && !included {
+			// content is only fetched when read
+			continue
+		}
+		it.curName = link.Name
+		it.curNode = node
+		return true
+	}
+}
+
+// nextLink the next link of the directory, nil at the end or on error
+func (it *dagIterator) nextLink() *ipld.Link {
+	if it.err != nil {
+		return nil
+	}
+	for {
+		if it.links == nil && it.errCh == nil {
+			return nil
+		}
+		select {
+		case l, ok := <-it.links:
+			if !ok {
+				it.links = nil
+				continue
+			}
+			return l
+		case err := <-it.errCh:
+			it.errCh = nil
+			if err != nil {
+				it.err = err
+				return nil
+			}
+		}
+	}
+}
+
+var _ files.Directory = &dagDirectory{}
+var _ files.File = &dagFile{}
@@ -0,0 +1,196 @@
+package titan_client
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	md "github.com/ipfs/go-merkledag"
+	ft "github.com/ipfs/go-unixfs"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// mapDAG an in memory DAGService
+type mapDAG map[cid.Cid]ipld.Node
+
+func (m mapDAG) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	nd, ok := m[c]
+	if !ok {
+		return nil, ipld.ErrNotFound{Cid: c}
+	}
+	return nd, nil
+}
+
+func (m mapDAG) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	out := make(chan *ipld.NodeOption, len(cids))
+	for _, c := range cids {
+		nd, err := m.Get(ctx, c)
+		out <- &ipld.NodeOption{Node: nd, Err: err}
+	}
+	close(out)
+	return out
+}
+
+func (m mapDAG) Add(ctx context.Context, nd ipld.Node) error {
+	m[nd.Cid()] = nd
+	return nil
+}
+
+func (m mapDAG) AddMany(ctx context.Context, nds []ipld.Node) error {
+	for _, nd := range nds {
+		m[nd.Cid()] = nd
+	}
+	return nil
+}
+
+func (m mapDAG) Remove(ctx context.Context, c cid.Cid) error {
+	delete(m, c)
+	return nil
+}
+
+func (m mapDAG) RemoveMany(ctx context.Context, cids []cid.Cid) error {
+	for _, c := range cids {
+		delete(m, c)
+	}
+	return nil
+}
+
+// withMeta append the UnixFS 1.5 mode and mtime fields to a Data message
+func withMeta(data []byte, mode uint32, mtime time.Time) []byte {
+	data = protowire.AppendTag(data, fieldMode, protowire.VarintType)
+	data = protowire.AppendVarint(data, uint64(mode))
+	var ut []byte
+	ut = protowire.AppendTag(ut, fieldMtimeSeconds, protowire.VarintType)
+	ut = protowire.AppendVarint(ut, uint64(mtime.Unix()))
+	ut = protowire.AppendTag(ut, fieldMtimeNanos, protowire.Fixed32Type)
+	ut = protowire.AppendFixed32(ut, uint32(mtime.Nanosecond()))
+	data = protowire.AppendTag(data, fieldMtime, protowire.BytesType)
+	return protowire.AppendBytes(data, ut)
+}
+
+// metaDAG a directory with an executable file and a plain file
+func metaDAG(t *testing.T, mtime time.Time) (ipld.DAGService, ipld.Node) {
+	ctx := context.Background()
+	ds := mapDAG{}
+	exe := md.NodeWithData(withMeta(ft.FilePBData([]byte("#!/bin/sh\n"), 10), 0755, mtime))
+	plain := md.NodeWithData(ft.FilePBData([]byte("titan"), 5))
+	dir := md.NodeWithData(withMeta(ft.FolderPBData(), 0750, mtime))
+	for name, nd := range map[string]*md.ProtoNode{"run.sh": exe, "a.txt": plain} {
+		if err := ds.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		if err := dir.AddNodeLink(name, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ds.Add(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	return ds, dir
+}
+
+func TestParseUnixfsMeta(t *testing.T) {
+	mtime := time.Unix(1600000000, 500)
+	meta := parseUnixfsMeta(withMeta(ft.FilePBData([]byte("titan"), 5), 04755, mtime))
+	if meta.mode != 04755 || !meta.mtime.Equal(mtime) {
+		t.Errorf("unexpected meta %o %v", meta.mode, meta.mtime)
+	}
+	if meta.fileMode(defaultFileMode) != os.ModeSetuid|0755 {
+		t.Errorf("unexpected file mode %v", meta.fileMode(defaultFileMode))
+	}
+	if meta = parseUnixfsMeta(ft.FilePBData([]byte("titan"), 5)); meta.mode != 0 || !meta.mtime.IsZero() {
+		t.Errorf("unexpected meta %o %v", meta.mode, meta.mtime)
+	}
+}
+
+func TestExtractMetadata(t *testing.T) {
+	mtime := time.Unix(1600000000, 0)
+	ds, root := metaDAG(t, mtime)
+
+	for _, ignore := range []bool{false, true} {
+		walker := &dagWalker{ctx: context.Background(), ds: ds, ignoreMeta: ignore}
+		node, err := walker.node(root)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		reader, err := fileArchive(node, "root", false, gzip.NoCompression, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		out := filepath.Join(t.TempDir(), "out")
+		ow := Writer{IgnoreMetadata: ignore}
+		if err = ow.Write(reader, out); err != nil {
+			t.Error(err)
+			return
+		}
+
+		want := map[string]os.FileMode{"run.sh": 0755, "a.txt": defaultFileMode, ".": 0750}
+		if ignore {
+			want = map[string]os.FileMode{"run.sh": defaultFileMode, "a.txt": defaultFileMode, ".": defaultDirMode}
+		}
+		for name, mode := range want {
+			info, err := os.Stat(filepath.Join(out, name))
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			// under the umask as a new file
+			if info.Mode().Perm() != mode&^umask {
+				t.Errorf("ignore %v: %s mode %v, want %v", ignore, name, info.Mode().Perm(), mode&^umask)
+			}
+			if name != "a.txt" && info.ModTime().Equal(mtime) == ignore {
+				t.Errorf("ignore %v: %s mtime %v", ignore, name, info.ModTime())
+			}
+		}
+	}
+}
+
+func TestRawReader(t *testing.T) {
+	ctx := context.Background()
+	ds, root := metaDAG(t, time.Unix(1600000000, 0))
+	settings := newDownloadSettings(WithRawOption())
+	walker := &dagWalker{ctx: ctx, ds: ds}
+
+	// a file root streams its content
+	exe, _, err := root.ResolveLink([]string{"run.sh"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, err := exe.GetNode(ctx, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := walker.node(nd)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	reader, err := nodeReader(file, nd.Cid().String(), false, gzip.NoCompression, settings)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data, err := io.ReadAll(reader)
+	_ = reader.Close()
+	if err != nil || string(data) != "#!/bin/sh\n" {
+		t.Errorf("unexpected content %q, %v", data, err)
+	}
+
+	// a directory root has no raw content
+	dir, err := walker.node(root)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err = nodeReader(dir, root.Cid().String(), false, gzip.NoCompression, settings); !errors.Is(err, ErrIsDirectory) {
+		t.Errorf("expected ErrIsDirectory, got %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package util
+
+import (
+	"container/list"
+	"github.com/ipfs/go-cid"
+	"sync"
+)
+
+// BlockCache a bounded in-memory LRU cache of verified blocks, limited by bytes.
+// safe for concurrent use, share one cache between fetchers and downloads
+type BlockCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	ll       *list.List
+	items    map[string]*list.Element
+	stats    CacheStats
+}
+
+// CacheStats hit and miss statistics of a BlockCache
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	// Blocks and Bytes currently cached
+	Blocks int
+	Bytes  int64
+}
+
+type cacheEntry struct {
+	key  string
+	data []byte
+}
+
+// NewBlockCache maxBytes the total size of cached blocks, eg: 64 << 20
+func NewBlockCache(maxBytes int64) *BlockCache {
+	return &BlockCache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Get returns the cached data of block c
+func (b *BlockCache) Get(c cid.Cid) ([]byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.items[c.KeyString()]
+	if !ok {
+		b.stats.Misses++
+		return nil, false
+	}
+	b.stats.Hits++
+	b.ll.MoveToFront(e)
+	return e.Value.(*cacheEntry).data, true
+}
+
+// Add cache the data of block c, evicting the least recently used blocks,
+// blocks larger than the cache are not cached
+func (b *BlockCache) Add(c cid.Cid, data []byte) {
+	size := int64(len(data))
+	if size > b.maxBytes {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := c.KeyString()
+	if e, ok := b.items[key]; ok {
+		b.ll.MoveToFront(e)
+		return
+	}
+	for b.stats.Bytes+size > b.maxBytes {
+		b.removeOldest()
+	}
+	b.items[key] = b.ll.PushFront(&cacheEntry{key: key, data: data})
+	b.stats.Blocks++
+	b.stats.Bytes += size
+}
+
+func (b *BlockCache) removeOldest() {
+	e := b.ll.Back()
+	if e == nil {
+		return
+	}
+	entry := b.ll.Remove(e).(*cacheEntry)
+	delete(b.items, entry.key)
+	b.stats.Evictions++
+	b.stats.Blocks--
+	b.stats.Bytes -= int64(len(entry.data))
+}
+
+// Stats returns a snapshot of the statistics
+func (b *BlockCache) Stats() CacheStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stats
+}
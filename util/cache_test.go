@@ -0,0 +1,43 @@
+package util
+
+import (
+	"github.com/ipfs/go-cid"
+	"testing"
+)
+
+func TestBlockCache(t *testing.T) {
+	ks := make([]cid.Cid, 0, 3)
+	for _, v := range []string{"first", "two", "three"} {
+		c, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: 0x12, MhLength: -1}.Sum([]byte(v))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ks = append(ks, c)
+	}
+
+	cache := NewBlockCache(10)
+	cache.Add(ks[0], []byte("first"))
+	cache.Add(ks[1], []byte("two"))
+	// first becomes the most recently used
+	if data, ok := cache.Get(ks[0]); !ok || string(data) != "first" {
+		t.Error("expected hit of first")
+		return
+	}
+	// 5 + 3 + 5 > 10, two is evicted
+	cache.Add(ks[2], []byte("three"))
+	if _, ok := cache.Get(ks[1]); ok {
+		t.Error("expected two to be evicted")
+		return
+	}
+	if _, ok := cache.Get(ks[2]); !ok {
+		t.Error("expected hit of three")
+		return
+	}
+
+	stats := cache.Stats()
+	t.Logf("%+v", stats)
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Evictions != 1 || stats.Blocks != 2 || stats.Bytes != 10 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
@@ -2,8 +2,8 @@ package util
 
 import (
 	"context"
-	"crypto/x509"
-	"encoding/pem"
+	"crypto"
+	"errors"
 	"fmt"
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
@@ -11,7 +11,10 @@ import (
 	"github.com/linguohua/titan/api"
 	"github.com/linguohua/titan/api/client"
 	http2 "github.com/timtide/titan-client/util/http"
-	"math/rand"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
+	"net/http"
 	"runtime"
 	"strings"
 	"sync"
@@ -24,6 +27,9 @@ const sdkName = "go-titan-client"
 
 var logger = logging.Logger("titan-client/util")
 
+// ErrNoAvailableNode all edge nodes of the carfile have been blacklisted
+var ErrNoAvailableNode = errors.New("no available edge node")
+
 type FetcherOption func(*fetcher)
 
 func WithLocatorAddressOption(locatorUrl string) FetcherOption {
@@ -32,6 +38,97 @@ func WithLocatorAddressOption(locatorUrl string) FetcherOption {
 	}
 }
 
+// WithMaxBlockSizeOption the largest block accepted from edge node or gateway,
+// larger responses are rejected with *http.TooLargeError.
+// default is http.DefaultMaxBlockSize
+func WithMaxBlockSizeOption(size int64) FetcherOption {
+	return func(dg *fetcher) {
+		dg.maxBlockSize = size
+	}
+}
+
+// WithHTTPClientOption the http client used to connect edge nodes, gateways and locator,
+// eg: with a proxy, custom CA bundle, mTLS or connection limits.
+// locator calls then go through a JSON-RPC client of this package instead of go-jsonrpc
+func WithHTTPClientOption(client *http.Client) FetcherOption {
+	return func(dg *fetcher) {
+		dg.httpClient = client
+	}
+}
+
+// WithRoundTripperOption the transport used to connect edge nodes, gateways and locator,
+// the client keeps the default timeout. ignored if WithHTTPClientOption is set
+func WithRoundTripperOption(rt http.RoundTripper) FetcherOption {
+	return func(dg *fetcher) {
+		dg.roundTripper = rt
+	}
+}
+
+// WithLimitsOption throttle the bandwidth and locator calls of the fetcher,
+// l can be changed at runtime and shared between fetchers
+func WithLimitsOption(l *Limits) FetcherOption {
+	return func(dg *fetcher) {
+		dg.limits = l
+	}
+}
+
+// WithSignerOption the identity of the fetcher, used for the public key sent to locator
+// and the callback signatures. default is the process identity of GetSigner
+func WithSignerOption(signer Signer) FetcherOption {
+	return func(dg *fetcher) {
+		dg.signer = AsKeySigner(signer)
+	}
+}
+
+// WithKeySignerOption as WithSignerOption with an identity of any key type, eg: Ed25519
+func WithKeySignerOption(signer KeySigner) FetcherOption {
+	return func(dg *fetcher) {
+		dg.signer = signer
+	}
+}
+
+// WithSchedulerPublicKeyOption verify every download info with the scheduler public key
+// before contacting the edge node, entries with a bad sign are dropped.
+// signature gives the signed message and the sign of a download info, it is required,
+// see ParsePublicKeyPem. default is no verification
+func WithSchedulerPublicKeyOption(publicKey crypto.PublicKey, signature DownloadInfoSignature) FetcherOption {
+	return func(dg *fetcher) {
+		dg.schedulerKey = publicKey
+		dg.signature = signature
+	}
+}
+
+// WithSelectorOption the strategy picking the edge node of every block,
+// eg: NewRoundRobinSelector. default is NewWeightedSelector
+func WithSelectorOption(selector Selector) FetcherOption {
+	return func(dg *fetcher) {
+		dg.selector = selector
+	}
+}
+
+// WithBlockCacheOption keep fetched blocks in memory, the cache is asked before the network.
+// share one cache between fetchers to reuse blocks across downloads
+func WithBlockCacheOption(cache *BlockCache) FetcherOption {
+	return func(dg *fetcher) {
+		dg.cache = cache
+	}
+}
+
+// WithObserverOption receive the events of the block downloads,
+// can be given several times
+func WithObserverOption(observer Observer) FetcherOption {
+	return func(dg *fetcher) {
+		dg.observers = append(dg.observers, observer)
+	}
+}
+
+// WithHeaderOption extra headers sent to edge nodes, gateways and locator
+func WithHeaderOption(header http.Header) FetcherOption {
+	return func(dg *fetcher) {
+		dg.header = header.Clone()
+	}
+}
+
 // Fetcher from titan or common gateway or local gateway to get data
 type Fetcher interface {
 	GetBlockData(ctx context.Context, c cid.Cid) ([]byte, error)
@@ -46,36 +143,123 @@ type fetcher struct {
 	locatorAddr string
 	// for carfile, root cid load failure record
 	err error
+	// the largest block accepted, bytes
+	maxBlockSize int64
+	httpClient   *http.Client
+	roundTripper http.RoundTripper
+	header       http.Header
+	// built from httpClient, roundTripper and header
+	client *http2.Client
+	// nil means unlimited
+	limits *Limits
+	// nil means GetSigner
+	signer KeySigner
+	// nil means download infos are not verified
+	schedulerKey crypto.PublicKey
+	signature    DownloadInfoSignature
+	selector     Selector
+	// nil means no cache
+	cache     *BlockCache
+	observers Observers
+	// edge node url that returned bad data
+	blacklist map[string]struct{}
+	// guard pool, err and blacklist
+	mu sync.Mutex
+	// concurrent first requests share one locator lookup
+	lookupGroup singleflight.Group
+	// concurrent requests for the same block share one edge download
+	blockGroup singleflight.Group
+	// create the locator client, replaced in tests
+	newLocator func(ctx context.Context, addr string, header http.Header) (locatorAPI, func(), error)
+}
+
+// lookupKey the key of the shared locator lookup, one carfile per fetcher
+const lookupKey = "download-infos"
+
+func newLocator(ctx context.Context, addr string, header http.Header) (locatorAPI, func(), error) {
+	locator, closer, err := client.NewLocator(ctx, addr, header)
+	return locator, closer, err
 }
 
 func NewFetcher(option ...FetcherOption) Fetcher {
-	dg := &fetcher{}
+	dg := &fetcher{blacklist: make(map[string]struct{}), newLocator: newLocator}
 	for _, v := range option {
 		v(dg)
 	}
 	if dg.locatorAddr == "" {
 		dg.locatorAddr = defaultLocatorAddress
 	}
+	if dg.maxBlockSize <= 0 {
+		dg.maxBlockSize = http2.DefaultMaxBlockSize
+	}
+	if dg.httpClient == nil && dg.roundTripper != nil {
+		dg.httpClient = &http.Client{Transport: dg.roundTripper, Timeout: http2.DefaultTimeout}
+	}
+	dg.client = http2.NewClient(dg.httpClient, dg.header)
+	if dg.httpClient != nil {
+		// go-jsonrpc can not use the injected client
+		httpClient := dg.httpClient
+		dg.newLocator = func(ctx context.Context, addr string, header http.Header) (locatorAPI, func(), error) {
+			return newRPCLocator(addr, httpClient, header), func() {}, nil
+		}
+	}
+	if dg.selector == nil {
+		dg.selector = NewWeightedSelector(nil)
+	}
 	if !strings.HasSuffix(dg.locatorAddr, "/rpc/v0") {
 		dg.locatorAddr = fmt.Sprintf("%s%s", dg.locatorAddr, "/rpc/v0")
 	}
 	return dg
 }
 
-func (d *fetcher) getDownloadInfosByRootCid(ctx context.Context, c cid.Cid) error {
-	locator, closer, err := client.NewLocator(ctx, d.locatorAddr, nil)
+func (d *fetcher) getSigner() KeySigner {
+	if d.signer != nil {
+		return d.signer
+	}
+	return AsKeySigner(GetSigner())
+}
+
+// waitLocator blocks until the locator rate limit allows one more call
+func (d *fetcher) waitLocator(ctx context.Context) error {
+	if d.limits == nil {
+		return nil
+	}
+	return d.limits.locator.WaitN(ctx, 1)
+}
+
+// throttles returns the bandwidth limits for traffic with url, node means edge node
+func (d *fetcher) throttles(url string, node bool) []http2.Throttle {
+	if d.limits == nil {
+		return nil
+	}
+	if node {
+		return []http2.Throttle{&d.limits.bandwidth, d.limits.node(url)}
+	}
+	return []http2.Throttle{&d.limits.bandwidth}
+}
+
+func (d *fetcher) getDownloadInfosByRootCid(ctx context.Context, c cid.Cid) (err error) {
+	if err = d.waitLocator(ctx); err != nil {
+		return err
+	}
+	ctx, span := tracer.Start(ctx, "fetcher.getDownloadInfosByRootCid", trace.WithAttributes(attribute.String("cid", c.String())))
+	start := time.Now()
+	defer func() {
+		recordLocatorLookup(ctx, start, err)
+		d.observers.LocatorLookupDone(c, len(d.availableDownloadInfos()), time.Since(start), err)
+		endSpan(span, err)
+	}()
+	locator, closer, err := d.newLocator(ctx, d.locatorAddr, d.locatorHeader(ctx))
 	if err != nil {
 		logger.Error("create schedule fail : ", err.Error())
 		return err
 	}
 	defer closer()
-	publicKey := GetSigner().GetPublicKey()
-	X509PublicKey := x509.MarshalPKCS1PublicKey(&publicKey)
-	publicKeyPem := pem.EncodeToMemory(
-		&pem.Block{
-			Type:  "RSA PUBLIC KEY",
-			Bytes: X509PublicKey,
-		})
+	publicKeyPem, err := MarshalPublicKeyPem(d.getSigner().PublicKey())
+	if err != nil {
+		logger.Error("marshal public key fail : ", err.Error())
+		return err
+	}
 	downloadInfos, err := locator.GetDownloadInfosWithCarfile(ctx, c.String(), string(publicKeyPem))
 	if err != nil {
 		logger.Error("get download info fail : ", err.Error())
@@ -86,69 +270,200 @@ func (d *fetcher) getDownloadInfosByRootCid(ctx context.Context, c cid.Cid) erro
 		return fmt.Errorf("%s%s", "titan does not cache the carfile cid : ", c.String())
 	}
 
+	if d.schedulerKey != nil {
+		downloadInfos = d.verifiedDownloadInfos(downloadInfos)
+		if len(downloadInfos) == 0 {
+			return fmt.Errorf("%w : no valid download info of carfile cid : %s", ErrBadDownloadInfoSign, c.String())
+		}
+	}
+
+	d.mu.Lock()
 	d.pool = downloadInfos
+	d.mu.Unlock()
 
 	return nil
 }
 
+// verifiedDownloadInfos drop the download infos that are not signed by the scheduler
+func (d *fetcher) verifiedDownloadInfos(downloadInfos []*api.DownloadInfoResult) []*api.DownloadInfoResult {
+	verified := make([]*api.DownloadInfoResult, 0, len(downloadInfos))
+	for _, v := range downloadInfos {
+		if err := verifyDownloadInfo(d.schedulerKey, v, d.signature); err != nil {
+			logger.Warn("drop download info : ", err.Error())
+			continue
+		}
+		verified = append(verified, v)
+	}
+	return verified
+}
+
 func (d *fetcher) GetBlockData(ctx context.Context, c cid.Cid) ([]byte, error) {
-	if d.err != nil {
-		return nil, d.err
+	if data, ok := d.getCache(c); ok {
+		recordFetched(ctx, SourceCache, len(data), time.Time{})
+		d.observers.BlockFetched(c, len(data), SourceCache, "", 0)
+		return data, nil
 	}
-	if d.pool == nil || len(d.pool) == 0 {
-		err := d.getDownloadInfosByRootCid(ctx, c)
-		if err != nil {
-			d.err = err
-			return nil, err
-		}
+	if err := d.loadDownloadInfos(ctx, c); err != nil {
+		return nil, err
 	}
-	df, err := d.allotDownloadInfo()
+	v, err := d.share(ctx, &d.blockGroup, c.KeyString(), func() (interface{}, error) {
+		return d.getBlockFromEdgeNode(ctx, c)
+	})
 	if err != nil {
 		return nil, err
 	}
-	data, err := d.getDataFromEdgeNode(df, c)
+	d.addCache(c, v.([]byte))
+	return v.([]byte), nil
+}
+
+func (d *fetcher) getCache(c cid.Cid) ([]byte, bool) {
+	if d.cache == nil {
+		return nil, false
+	}
+	return d.cache.Get(c)
+}
+
+// addCache only verified data is added
+func (d *fetcher) addCache(c cid.Cid, data []byte) {
+	if d.cache != nil {
+		d.cache.Add(c, data)
+	}
+}
+
+// loadDownloadInfos ask locator for the edge nodes of the carfile on first use,
+// the first cid requested is the root cid of the carfile
+func (d *fetcher) loadDownloadInfos(ctx context.Context, c cid.Cid) error {
+	d.mu.Lock()
+	loaded, err := len(d.pool) > 0, d.err
+	d.mu.Unlock()
 	if err != nil {
-		logger.Error("fail get data from edge node : ", err.Error())
-		if strings.Contains(strings.ToLower(err.Error()), "not found") {
+		return err
+	}
+	if loaded {
+		return nil
+	}
+	_, err = d.share(ctx, &d.lookupGroup, lookupKey, func() (interface{}, error) {
+		d.mu.Lock()
+		loaded, err := len(d.pool) > 0, d.err
+		d.mu.Unlock()
+		if err != nil || loaded {
 			return nil, err
 		}
-		go d.callback(c, df.SN, false)
+		err = d.getDownloadInfosByRootCid(ctx, c)
+		// a cancelled lookup says nothing about the carfile, allow the next call to retry
+		if err != nil && ctx.Err() == nil {
+			d.mu.Lock()
+			d.err = err
+			d.mu.Unlock()
+		}
 		return nil, err
-	}
-	go d.callback(c, df.SN, true)
-	return data, nil
+	})
+	return err
 }
 
-func (d *fetcher) allotDownloadInfo() (*api.DownloadInfoResult, error) {
-	if len(d.pool) == 1 {
-		return d.pool[0], nil
-	}
-	weightAllot := false
-	for _, v := range d.pool {
-		if v.Weight != 0 {
-			weightAllot = true
-			break
+// share run fn once for all concurrent callers of key.
+// fn runs with the context of the first caller, if that one is cancelled
+// the callers whose context is still alive run it again
+func (d *fetcher) share(ctx context.Context, g *singleflight.Group, key string, fn func() (interface{}, error)) (interface{}, error) {
+	for {
+		select {
+		case res := <-g.DoChan(key, fn):
+			cancelled := errors.Is(res.Err, context.Canceled) || errors.Is(res.Err, context.DeadlineExceeded)
+			if cancelled && ctx.Err() == nil {
+				continue
+			}
+			return res.Val, res.Err
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		}
 	}
-	if weightAllot {
-		cs, err := NewChooser(d.pool...)
+}
+
+func (d *fetcher) getBlockFromEdgeNode(ctx context.Context, c cid.Cid) ([]byte, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		df, err := d.allotDownloadInfo(c)
 		if err != nil {
 			return nil, err
 		}
-		return cs.Pick(), nil
+		start := time.Now()
+		data, err := d.getDataFromEdgeNode(ctx, df, c)
+		if err == nil {
+			err = verifyBlockData(c, data)
+		}
+		d.selector.Done(df, err)
+		if err != nil {
+			recordError(ctx, SourceEdge, err)
+			d.observers.NodeFailed(c, df.URL, err)
+		} else {
+			recordFetched(ctx, SourceEdge, len(data), start)
+			d.observers.BlockFetched(c, len(data), SourceEdge, df.URL, time.Since(start))
+		}
+		if errors.Is(err, ErrHashMismatch) {
+			// bad data, never ask this node again and retry elsewhere
+			logger.Errorf("edge node [%s] returned bad data : %s", df.URL, err.Error())
+			d.addBlacklist(df.URL)
+			go d.callback(ctx, c, df.SN, false)
+			continue
+		}
+		if err != nil {
+			logger.Error("fail get data from edge node : ", err.Error())
+			if strings.Contains(strings.ToLower(err.Error()), "not found") {
+				return nil, err
+			}
+			if ctx.Err() != nil {
+				// cancelled by the caller, not a failure of the edge node
+				return nil, err
+			}
+			go d.callback(ctx, c, df.SN, false)
+			return nil, err
+		}
+		go d.callback(ctx, c, df.SN, true)
+		return data, nil
+	}
+}
+
+func (d *fetcher) addBlacklist(url string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.blacklist[url] = struct{}{}
+}
+
+// availableDownloadInfos returns the edge nodes that are not blacklisted
+func (d *fetcher) availableDownloadInfos() []*api.DownloadInfoResult {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.blacklist) == 0 {
+		return d.pool
+	}
+	available := make([]*api.DownloadInfoResult, 0, len(d.pool))
+	for _, v := range d.pool {
+		if _, ok := d.blacklist[v.URL]; ok {
+			continue
+		}
+		available = append(available, v)
+	}
+	return available
+}
+
+func (d *fetcher) allotDownloadInfo(c cid.Cid) (*api.DownloadInfoResult, error) {
+	pool := d.availableDownloadInfos()
+	if len(pool) == 0 {
+		return nil, ErrNoAvailableNode
 	}
-	rand.Seed(time.Now().UnixNano())
-	index := rand.Intn(len(d.pool))
-	return d.pool[index], nil
+	return d.selector.Select(c, pool), nil
 }
 
 func (d *fetcher) GetBlockDataFromTitanOrGateway(ctx context.Context, customGatewayAddr string, c cid.Cid) ([]byte, error) {
 	data, err := d.GetBlockData(ctx, c)
-	if err != nil && !strings.Contains(strings.ToLower(err.Error()), "not found") {
+	if err != nil && !errors.Is(err, ErrNoAvailableNode) && !strings.Contains(strings.ToLower(err.Error()), "not found") {
 		return nil, err
 	}
 	if data == nil {
-		data, err = d.getDataFromCommonGateway(customGatewayAddr, c)
+		d.observers.GatewayFallback(c, customGatewayAddr, err)
+		data, err = d.getDataFromCommonGateway(ctx, customGatewayAddr, c)
 		if err != nil {
 			logger.Error("fail get data from gateway : ", err.Error())
 			return nil, err
@@ -164,14 +479,21 @@ func (d *fetcher) GetBlocksFromTitanOrGateway(ctx context.Context, customGateway
 
 		var wg sync.WaitGroup
 		for _, v := range ks {
+			if ctx.Err() != nil {
+				break
+			}
 			value := v
 
 			wg.Add(1)
 			go func(cc context.Context, c cid.Cid) {
 				defer wg.Done()
 				data, err := d.GetBlockData(cc, c)
+				if cc.Err() != nil {
+					return
+				}
 				if data == nil {
-					data, err = d.getDataFromCommonGateway(customGatewayAddr, c)
+					d.observers.GatewayFallback(c, customGatewayAddr, err)
+					data, err = d.getDataFromCommonGateway(cc, customGatewayAddr, c)
 					if err != nil {
 						logger.Error("fail get data from gateway : ", err.Error())
 						return
@@ -203,6 +525,9 @@ func (d *fetcher) GetBlocksFromTitan(ctx context.Context, ks []cid.Cid) <-chan b
 
 		var wg sync.WaitGroup
 		for _, v := range ks {
+			if ctx.Err() != nil {
+				break
+			}
 			value := v
 			wg.Add(1)
 			go func(cc context.Context, c cid.Cid) {
@@ -233,7 +558,17 @@ func (d *fetcher) GetBlocksFromTitan(ctx context.Context, ks []cid.Cid) <-chan b
 }
 
 // getDataFromEdgeNode connect Titan edge node by http get method
-func (d *fetcher) getDataFromEdgeNode(di *api.DownloadInfoResult, cid cid.Cid) ([]byte, error) {
+func (d *fetcher) getDataFromEdgeNode(ctx context.Context, di *api.DownloadInfoResult, cid cid.Cid) (data []byte, err error) {
+	ctx, span := tracer.Start(ctx, "fetcher.getDataFromEdgeNode", trace.WithAttributes(
+		attribute.String("cid", cid.String()),
+		attribute.String("node", di.URL),
+		attribute.String("source", SourceEdge),
+	))
+	defer func() {
+		span.SetAttributes(attribute.Int("bytes", len(data)))
+		endSpan(span, err)
+	}()
+
 	if di.URL == "" {
 		return nil, fmt.Errorf("not found target host")
 	}
@@ -247,23 +582,60 @@ func (d *fetcher) getDataFromEdgeNode(di *api.DownloadInfoResult, cid cid.Cid) (
 		di.SN,
 		di.SignTime,
 		di.TimeOut)
-	return http2.Get(url, sdkName)
+	return d.client.Get(ctx, url, sdkName, d.maxBlockSize, d.throttles(di.URL, true)...)
 }
 
-func (d *fetcher) getDataFromCommonGateway(customGatewayAddr string, c cid.Cid) ([]byte, error) {
+func (d *fetcher) getDataFromCommonGateway(ctx context.Context, customGatewayAddr string, c cid.Cid) (data []byte, err error) {
+	ctx, span := tracer.Start(ctx, "fetcher.getDataFromCommonGateway", trace.WithAttributes(
+		attribute.String("cid", c.String()),
+		attribute.String("node", customGatewayAddr),
+		attribute.String("source", SourceGateway),
+	))
+	defer func() {
+		span.SetAttributes(attribute.Int("bytes", len(data)))
+		endSpan(span, err)
+	}()
+
 	if customGatewayAddr == "" {
 		return nil, fmt.Errorf("not found target host")
 	}
 	logger.Debugf("got data from common gateway with cid [%s]", c.String())
 	url := fmt.Sprintf("%s%s", customGatewayAddr, c.String())
-	return http2.PostFromGateway(url)
+	start := time.Now()
+	data, err = d.client.PostFromGateway(ctx, url, d.maxBlockSize, d.throttles(customGatewayAddr, false)...)
+	if err == nil {
+		err = verifyBlockData(c, data)
+	}
+	if err != nil {
+		recordError(ctx, SourceGateway, err)
+		return nil, err
+	}
+	recordFetched(ctx, SourceGateway, len(data), start)
+	d.observers.BlockFetched(c, len(data), SourceGateway, customGatewayAddr, time.Since(start))
+	d.addCache(c, data)
+	return data, nil
 }
 
-func (d *fetcher) callback(c cid.Cid, sn int64, downloadSuccess bool) {
+func (d *fetcher) callback(ctx context.Context, c cid.Cid, sn int64, downloadSuccess bool) {
 	// give up the CPU, download first
 	runtime.Gosched()
 
-	locator, closer, err := client.NewLocator(context.TODO(), d.locatorAddr, nil)
+	// the callback is sent even if the download was cancelled meanwhile
+	ctx, span := tracer.Start(detachedContext(ctx), "fetcher.callback", trace.WithAttributes(
+		attribute.String("cid", c.String()),
+		attribute.Int64("sn", sn),
+		attribute.Bool("success", downloadSuccess),
+	))
+	var err error
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	if err = d.waitLocator(ctx); err != nil {
+		return
+	}
+
+	locator, closer, err := d.newLocator(ctx, d.locatorAddr, d.locatorHeader(ctx))
 	if err != nil {
 		logger.Error("create schedule fail : ", err.Error())
 		return
@@ -272,7 +644,7 @@ func (d *fetcher) callback(c cid.Cid, sn int64, downloadSuccess bool) {
 
 	logger.Debugf("[%s] downlaod state : %v", c.String(), downloadSuccess)
 
-	cidSign, err := GetSigner().Sign([]byte(c.String()))
+	cidSign, err := d.getSigner().Sign([]byte(c.String()))
 	if err != nil {
 		logger.Warn("cid sign fail : ", err.Error())
 		return
@@ -285,7 +657,9 @@ func (d *fetcher) callback(c cid.Cid, sn int64, downloadSuccess bool) {
 		},
 	}
 
-	err = locator.UserDownloadBlockResults(context.TODO(), bdResult)
+	err = locator.UserDownloadBlockResults(ctx, bdResult)
+	recordCallback(ctx, err == nil)
+	d.observers.CallbackSent(c, sn, downloadSuccess, err)
 	if err != nil {
 		logger.Warnf("[%s] download callback fail : %s", c.String(), err.Error())
 		return
@@ -0,0 +1,329 @@
+package util
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"github.com/ipfs/go-cid"
+	"github.com/linguohua/titan/api"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeLocator returns the edge nodes of the test
+type fakeLocator struct {
+	lookups int32
+	nodes   []*api.DownloadInfoResult
+}
+
+func (l *fakeLocator) GetDownloadInfosWithCarfile(ctx context.Context, cid string, publicKey string) ([]*api.DownloadInfoResult, error) {
+	atomic.AddInt32(&l.lookups, 1)
+	time.Sleep(50 * time.Millisecond)
+	return l.nodes, nil
+}
+
+func (l *fakeLocator) UserDownloadBlockResults(ctx context.Context, results []api.UserBlockDownloadResult) error {
+	return nil
+}
+
+// countObserver counts the events of the test
+type countObserver struct {
+	NopObserver
+	lookups int32
+	fetched int32
+}
+
+func (o *countObserver) LocatorLookupDone(root cid.Cid, nodes int, duration time.Duration, err error) {
+	atomic.AddInt32(&o.lookups, 1)
+}
+
+func (o *countObserver) BlockFetched(c cid.Cid, size int, source string, node string, duration time.Duration) {
+	atomic.AddInt32(&o.fetched, 1)
+}
+
+func TestFetcherConcurrentGetBlockData(t *testing.T) {
+	blocks := make(map[string][]byte)
+	ks := make([]cid.Cid, 0, 4)
+	for _, v := range []string{"first", "two", "three", "four"} {
+		c, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: 0x12, MhLength: -1}.Sum([]byte(v))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		blocks[c.String()] = []byte(v)
+		ks = append(ks, c)
+	}
+
+	var requests sync.Map
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Query().Get("cid")
+		count, _ := requests.LoadOrStore(key, new(int32))
+		atomic.AddInt32(count.(*int32), 1)
+		time.Sleep(50 * time.Millisecond)
+		_, _ = w.Write(blocks[key])
+	}))
+	defer srv.Close()
+
+	locator := &fakeLocator{nodes: []*api.DownloadInfoResult{
+		{URL: srv.URL + "/a", Sign: "sign", SN: 1},
+		{URL: srv.URL + "/b", Sign: "sign", SN: 2},
+	}}
+	observer := &countObserver{}
+	f := NewFetcher(WithLocatorAddressOption("http://127.0.0.1:5000"), WithObserverOption(observer)).(*fetcher)
+	f.newLocator = func(ctx context.Context, addr string, header http.Header) (locatorAPI, func(), error) {
+		return locator, func() {}, nil
+	}
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		c := ks[i%len(ks)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := f.GetBlockData(ctx, c)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if string(data) != string(blocks[c.String()]) {
+				t.Errorf("unexpected data of %s", c.String())
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&locator.lookups); n != 1 {
+		t.Errorf("expected one locator lookup, got %d", n)
+	}
+	if observer.lookups != 1 || observer.fetched != int32(len(ks)) {
+		t.Errorf("unexpected events, lookups %d, fetched %d", observer.lookups, observer.fetched)
+	}
+	requests.Range(func(key, value interface{}) bool {
+		if n := atomic.LoadInt32(value.(*int32)); n != 1 {
+			t.Errorf("expected one edge download of %s, got %d", key, n)
+		}
+		return true
+	})
+}
+
+// countingTransport counts the requests sent through it
+type countingTransport struct {
+	requests int32
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&c.requests, 1)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestFetcherHTTPClient(t *testing.T) {
+	block := []byte("hello titan !!!")
+	c, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: 0x12, MhLength: -1}.Sum(block)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// every server checks the injected header
+	var edgeRequests, gatewayRequests, locatorRequests int32
+	checkHeader := func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Header.Get("X-Titan-Test") != "client" {
+			t.Errorf("missing header on %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusForbidden)
+			return false
+		}
+		return true
+	}
+	edge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&edgeRequests, 1)
+		if checkHeader(w, r) {
+			_, _ = w.Write(block)
+		}
+	}))
+	defer edge.Close()
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&gatewayRequests, 1)
+		if checkHeader(w, r) && r.Method == http.MethodPost && r.URL.Path == "/ipfs/"+c.String() {
+			_, _ = w.Write(block)
+		}
+	}))
+	defer gateway.Close()
+	locator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&locatorRequests, 1)
+		if !checkHeader(w, r) {
+			return
+		}
+		var req struct {
+			ID     int64
+			Method string
+			Params []json.RawMessage
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var result interface{}
+		switch req.Method {
+		case "titan.GetDownloadInfosWithCarfile":
+			result = []*api.DownloadInfoResult{{URL: edge.URL + "/block/get", Sign: "sign", SN: 1}}
+		case "titan.UserDownloadBlockResults":
+		default:
+			t.Errorf("unexpected method %s", req.Method)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": result})
+	}))
+	defer locator.Close()
+
+	transport := &countingTransport{}
+	f := NewFetcher(
+		WithLocatorAddressOption(locator.URL),
+		WithRoundTripperOption(transport),
+		WithHeaderOption(http.Header{"X-Titan-Test": []string{"client"}}),
+	).(*fetcher)
+
+	ctx := context.Background()
+	data, err := f.GetBlockData(ctx, c)
+	if err != nil || string(data) != string(block) {
+		t.Errorf("edge block %q: %v", data, err)
+	}
+	data, err = f.getDataFromCommonGateway(ctx, gateway.URL+"/ipfs/", c)
+	if err != nil || string(data) != string(block) {
+		t.Errorf("gateway block %q: %v", data, err)
+	}
+
+	if atomic.LoadInt32(&locatorRequests) == 0 || atomic.LoadInt32(&edgeRequests) != 1 || atomic.LoadInt32(&gatewayRequests) != 1 {
+		t.Errorf("unexpected requests, locator %d, edge %d, gateway %d", locatorRequests, edgeRequests, gatewayRequests)
+	}
+	// the success callback to locator is sent in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&locatorRequests) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	sent := atomic.LoadInt32(&locatorRequests) + atomic.LoadInt32(&edgeRequests) + atomic.LoadInt32(&gatewayRequests)
+	if n := atomic.LoadInt32(&transport.requests); n != sent || sent != 4 {
+		t.Errorf("expected the 4 requests through the transport, got %d of %d", n, sent)
+	}
+}
+
+// recordLocator records the public key and the callbacks received
+type recordLocator struct {
+	fakeLocator
+	mu        sync.Mutex
+	publicKey string
+	results   []api.UserBlockDownloadResult
+}
+
+func (l *recordLocator) GetDownloadInfosWithCarfile(ctx context.Context, cid string, publicKey string) ([]*api.DownloadInfoResult, error) {
+	l.mu.Lock()
+	l.publicKey = publicKey
+	l.mu.Unlock()
+	return l.nodes, nil
+}
+
+func (l *recordLocator) UserDownloadBlockResults(ctx context.Context, results []api.UserBlockDownloadResult) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.results = append(l.results, results...)
+	return nil
+}
+
+func TestFetcherSigner(t *testing.T) {
+	block := []byte("hello titan !!!")
+	c, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: 0x12, MhLength: -1}.Sum(block)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(block)
+	}))
+	defer srv.Close()
+
+	signer, err := NewRSASigner(2048)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	locator := &recordLocator{fakeLocator: fakeLocator{nodes: []*api.DownloadInfoResult{{URL: srv.URL, Sign: "sign", SN: 7}}}}
+	f := NewFetcher(WithLocatorAddressOption("http://127.0.0.1:5000"), WithSignerOption(signer)).(*fetcher)
+	f.newLocator = func(ctx context.Context, addr string, header http.Header) (locatorAPI, func(), error) {
+		return locator, func() {}, nil
+	}
+	if _, err = f.GetBlockData(context.Background(), c); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// the public key of the injected signer, not of the process identity
+	publicKey := signer.GetPublicKey()
+	want, _ := MarshalPublicKeyPem(&publicKey)
+	locator.mu.Lock()
+	got := locator.publicKey
+	locator.mu.Unlock()
+	if got != string(want) {
+		t.Errorf("locator received another public key:\n%s", got)
+	}
+
+	// the callback is signed in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		locator.mu.Lock()
+		results := locator.results
+		locator.mu.Unlock()
+		if len(results) > 0 {
+			if results[0].SN != 7 || !results[0].Result || !signer.VerifySign([]byte(c.String()), results[0].Sign) {
+				t.Errorf("callback not signed by the injected signer: %+v", results[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Error("no callback received")
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFetcherEd25519Signer(t *testing.T) {
+	block := []byte("hello titan !!!")
+	c, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: 0x12, MhLength: -1}.Sum(block)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(block)
+	}))
+	defer srv.Close()
+
+	signer, err := NewEd25519Signer()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	locator := &recordLocator{fakeLocator: fakeLocator{nodes: []*api.DownloadInfoResult{{URL: srv.URL, Sign: "sign", SN: 7}}}}
+	f := NewFetcher(WithLocatorAddressOption("http://127.0.0.1:5000"), WithKeySignerOption(signer)).(*fetcher)
+	f.newLocator = func(ctx context.Context, addr string, header http.Header) (locatorAPI, func(), error) {
+		return locator, func() {}, nil
+	}
+	if _, err = f.GetBlockData(context.Background(), c); err != nil {
+		t.Error(err)
+		return
+	}
+	locator.mu.Lock()
+	got := locator.publicKey
+	locator.mu.Unlock()
+	publicKey, err := ParsePublicKeyPem([]byte(got))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if pk, ok := publicKey.(ed25519.PublicKey); !ok || !pk.Equal(signer.PublicKey()) {
+		t.Errorf("locator received another public key:\n%s", got)
+	}
+}
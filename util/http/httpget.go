@@ -1,70 +1,178 @@
 package http
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 	"io"
 	"net/http"
+	"sync"
 	"time"
 )
 
-// Get connect to other
+// DefaultMaxBlockSize the largest response body accepted for one block, 4MiB.
+// ipfs blocks are at most 2MiB in practice, leave room for custom chunkers
+const DefaultMaxBlockSize int64 = 4 << 20
+
+// TooLargeError the response body exceeds the maximum block size
+type TooLargeError struct {
+	URL   string
+	Size  int64 // declared or read size, at least Limit+1
+	Limit int64
+}
+
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("response of [%s] is too large : %d bytes, limit %d bytes", e.URL, e.Size, e.Limit)
+}
+
+// DefaultTimeout the timeout of the http client used when none is supplied
+const DefaultTimeout = 30 * time.Second
+
+// Client sends block requests to edge nodes and gateways.
+// the *http.Client is shared by all requests, so connections can be reused
+type Client struct {
+	httpClient *http.Client
+	// extra headers sent with every request
+	header http.Header
+}
+
+// NewClient httpClient nil means a client with DefaultTimeout,
+// header is added to every request and may override App-Name
+func NewClient(httpClient *http.Client, header http.Header) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: DefaultTimeout}
+	}
+	return &Client{httpClient: httpClient, header: header.Clone()}
+}
+
+var defaultClient = NewClient(nil, nil)
+
+// Throttle blocks until n more bytes may be read, eg: a token bucket
+type Throttle interface {
+	WaitN(ctx context.Context, n int) error
+}
+
+// throttleChunk the largest read between two throttle waits, keeps the rate smooth
+const throttleChunk = 32 << 10
+
+type throttledReader struct {
+	ctx       context.Context
+	r         io.Reader
+	throttles []Throttle
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		for _, v := range t.throttles {
+			if werr := v.WaitN(t.ctx, n); werr != nil {
+				return n, werr
+			}
+		}
+	}
+	return n, err
+}
+
+// bufPool reduces allocations when reading response bodies
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// Get connect to other with the default client
+// ctx: cancel or deadline aborts the request immediately
 // url: url
-// token: token
 // appName: use of scheduler tracking information, optional
-func Get(url, appName string) ([]byte, error) {
-	client := &http.Client{Timeout: 30 * time.Second}
-	request, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
+// maxSize: the largest accepted body, <= 0 means DefaultMaxBlockSize
+// throttle: the body is read no faster than every throttle allows, optional
+func Get(ctx context.Context, url, appName string, maxSize int64, throttle ...Throttle) ([]byte, error) {
+	return defaultClient.Get(ctx, url, appName, maxSize, throttle...)
+}
 
-	request.Header.Set("App-Name", appName)
+// PostFromGateway get block from gateway with the default client
+func PostFromGateway(ctx context.Context, url string, maxSize int64, throttle ...Throttle) ([]byte, error) {
+	return defaultClient.PostFromGateway(ctx, url, maxSize, throttle...)
+}
 
-	// request do
-	resp, err := client.Do(request)
+// Get connect to other
+// ctx: cancel or deadline aborts the request immediately
+// url: url
+// appName: use of scheduler tracking information, optional
+// maxSize: the largest accepted body, <= 0 means DefaultMaxBlockSize
+// throttle: the body is read no faster than every throttle allows, optional
+func (c *Client) Get(ctx context.Context, url, appName string, maxSize int64, throttle ...Throttle) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	// Judge the return status
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("%s", resp.Status)
-	}
+	request.Header.Set("App-Name", appName)
 
-	defer resp.Body.Close()
+	return c.do(request, maxSize, throttle)
+}
 
-	result, err := io.ReadAll(resp.Body)
+func (c *Client) PostFromGateway(ctx context.Context, url string, maxSize int64, throttle ...Throttle) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, "POST", url, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	return result, nil
+	return c.do(request, maxSize, throttle)
 }
 
-func PostFromGateway(url string) ([]byte, error) {
-	client := &http.Client{Timeout: 30 * time.Second}
-	request, err := http.NewRequest("POST", url, nil)
-	if err != nil {
-		return nil, err
+func (c *Client) do(request *http.Request, maxSize int64, throttle []Throttle) ([]byte, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxBlockSize
+	}
+	for k, v := range c.header {
+		request.Header[k] = v
 	}
+	// trace context for correlation with edge node logs
+	otel.GetTextMapPropagator().Inject(request.Context(), propagation.HeaderCarrier(request.Header))
 
 	// request do
-	resp, err := client.Do(request)
+	resp, err := c.httpClient.Do(request)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
 	// Judge the return status
 	if resp.StatusCode != 200 {
 		return nil, fmt.Errorf("%s", resp.Status)
 	}
 
-	defer resp.Body.Close()
+	// reject early when the server declares a larger body
+	if resp.ContentLength > maxSize {
+		return nil, &TooLargeError{URL: request.URL.String(), Size: resp.ContentLength, Limit: maxSize}
+	}
+
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufPool.Put(buf)
 
-	result, err := io.ReadAll(resp.Body)
+	var body io.Reader = resp.Body
+	if len(throttle) > 0 {
+		body = &throttledReader{ctx: request.Context(), r: body, throttles: throttle}
+	}
+
+	// read one more byte than allowed to detect an oversized body without a Content-Length
+	n, err := buf.ReadFrom(io.LimitReader(body, maxSize+1))
 	if err != nil {
 		return nil, err
 	}
+	if n > maxSize {
+		return nil, &TooLargeError{URL: request.URL.String(), Size: n, Limit: maxSize}
+	}
 
+	// the buffer goes back to the pool, hand out a copy
+	result := make([]byte, buf.Len())
+	copy(result, buf.Bytes())
 	return result, nil
 }
@@ -0,0 +1,136 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetMaxSize(t *testing.T) {
+	body := bytes.Repeat([]byte("t"), 1024)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("chunked") != "" {
+			// no Content-Length, body is streamed
+			w.(http.Flusher).Flush()
+		}
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	data, err := Get(context.Background(), srv.URL, "test", 1024)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !bytes.Equal(data, body) {
+		t.Error("unexpected body")
+		return
+	}
+
+	for _, url := range []string{srv.URL, srv.URL + "?chunked=1"} {
+		_, err = Get(context.Background(), url, "test", 1023)
+		var tooLarge *TooLargeError
+		if !errors.As(err, &tooLarge) {
+			t.Errorf("[%s] expected too large error, got %v", url, err)
+			return
+		}
+		t.Log(tooLarge.Error())
+	}
+}
+
+func TestGetCancel(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := Get(ctx, srv.URL, "test", 0)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+		return
+	}
+	if time.Since(start) > DefaultTimeout/2 {
+		t.Error("request was not aborted by the context")
+	}
+}
+
+func TestGetTraceContext(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+
+	var traceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+	defer srv.Close()
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x01},
+		SpanID:     trace.SpanID{0x02},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithRemoteSpanContext(context.Background(), sc)
+	if _, err := Get(ctx, srv.URL, "test", 0); err != nil {
+		t.Error(err)
+		return
+	}
+	if traceparent != "00-01000000000000000000000000000000-0200000000000000-01" {
+		t.Errorf("unexpected traceparent %q", traceparent)
+	}
+}
+
+// countThrottle records the waits, fails after limit bytes if limit > 0
+type countThrottle struct {
+	waits []int
+	total int
+	limit int
+}
+
+func (c *countThrottle) WaitN(ctx context.Context, n int) error {
+	c.waits = append(c.waits, n)
+	c.total += n
+	if c.limit > 0 && c.total > c.limit {
+		return errors.New("throttled")
+	}
+	return nil
+}
+
+func TestThrottledReader(t *testing.T) {
+	body := bytes.Repeat([]byte("t"), 100<<10)
+	global, node := &countThrottle{}, &countThrottle{}
+	r := &throttledReader{ctx: context.Background(), r: bytes.NewReader(body), throttles: []Throttle{global, node}}
+	data, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(data, body) {
+		t.Errorf("unexpected body %d bytes: %v", len(data), err)
+		return
+	}
+	for _, c := range []*countThrottle{global, node} {
+		if c.total != len(body) {
+			t.Errorf("throttled %d bytes, expected %d", c.total, len(body))
+		}
+		for _, n := range c.waits {
+			if n > throttleChunk {
+				t.Errorf("chunk of %d bytes larger than %d", n, throttleChunk)
+			}
+		}
+	}
+
+	// the error of a throttle stops the read
+	r = &throttledReader{ctx: context.Background(), r: bytes.NewReader(body), throttles: []Throttle{&countThrottle{limit: 40 << 10}}}
+	if _, err = io.ReadAll(r); err == nil || err.Error() != "throttled" {
+		t.Errorf("expected throttle error, got %v", err)
+	}
+}
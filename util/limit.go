@@ -0,0 +1,177 @@
+package util
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// nodeIdleTimeout the per node buckets unused for that long are dropped
+const nodeIdleTimeout = 5 * time.Minute
+
+// Limits throttles the traffic of one or more fetchers,
+// all limits are safe to change at runtime, <= 0 means unlimited.
+// share one Limits between fetchers to apply a process wide cap.
+// the zero value is unlimited and ready to use
+type Limits struct {
+	// bytes per second of all edge node and gateway traffic
+	bandwidth tokenBucket
+	// requests per second to locator, download info and callback
+	locator tokenBucket
+
+	mu sync.Mutex
+	// bytes per second of a single edge node
+	nodeBandwidth float64
+	nodes         map[string]*tokenBucket
+	lastSweep     time.Time
+}
+
+func NewLimits() *Limits {
+	return &Limits{}
+}
+
+// SetBandwidth global bytes per second cap
+func (l *Limits) SetBandwidth(bytesPerSecond int64) {
+	l.bandwidth.setRate(float64(bytesPerSecond))
+}
+
+// SetNodeBandwidth bytes per second cap of each edge node
+func (l *Limits) SetNodeBandwidth(bytesPerSecond int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.nodeBandwidth = float64(bytesPerSecond)
+	for _, v := range l.nodes {
+		v.setRate(l.nodeBandwidth)
+	}
+}
+
+// SetLocatorRate requests per second cap of locator calls
+func (l *Limits) SetLocatorRate(requestsPerSecond float64) {
+	l.locator.setRate(requestsPerSecond)
+}
+
+func (l *Limits) node(url string) *tokenBucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.lastSweep) >= nodeIdleTimeout {
+		l.sweep(now)
+	}
+	b, ok := l.nodes[url]
+	if !ok {
+		if l.nodes == nil {
+			l.nodes = make(map[string]*tokenBucket)
+		}
+		b = newTokenBucket(l.nodeBandwidth)
+		b.used = now
+		l.nodes[url] = b
+	}
+	return b
+}
+
+// sweep drop the node buckets idle since nodeIdleTimeout and refilled,
+// a new bucket for the node would allow the same traffic
+func (l *Limits) sweep(now time.Time) {
+	l.lastSweep = now
+	for url, b := range l.nodes {
+		if b.idle(now, nodeIdleTimeout) {
+			delete(l.nodes, url)
+		}
+	}
+}
+
+// tokenBucket allows rate tokens per second with a burst of one second,
+// the zero value is unlimited
+type tokenBucket struct {
+	mu sync.Mutex
+	// tokens per second, <= 0 means unlimited
+	rate   float64
+	tokens float64
+	last   time.Time
+	// used the last WaitN
+	used time.Time
+	// changed closed when the rate changes, the waiters recompute their wait
+	changed chan struct{}
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	return &tokenBucket{rate: rate, tokens: rate, last: time.Now()}
+}
+
+func (b *tokenBucket) setRate(rate float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.refill(now)
+	if b.rate <= 0 {
+		// the burst of the new limit is available immediately
+		b.tokens = rate
+	}
+	b.rate = rate
+	if b.tokens > rate {
+		b.tokens = rate
+	}
+	if b.changed != nil {
+		close(b.changed)
+		b.changed = nil
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if b.rate > 0 {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.rate {
+			b.tokens = b.rate
+		}
+	}
+	b.last = now
+}
+
+// idle whether the bucket was not used since timeout and is full
+func (b *tokenBucket) idle(now time.Time, timeout time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	return now.Sub(b.used) >= timeout && (b.rate <= 0 || b.tokens >= b.rate)
+}
+
+// WaitN blocks until n tokens are available or ctx is done.
+// n larger than the burst is allowed once the bucket is full, the bucket goes into debt.
+// a rate change wakes the waiters
+func (b *tokenBucket) WaitN(ctx context.Context, n int) error {
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.used = now
+		if b.rate <= 0 {
+			b.mu.Unlock()
+			return nil
+		}
+		b.refill(now)
+		need := float64(n)
+		if need > b.rate {
+			need = b.rate
+		}
+		if b.tokens >= need {
+			b.tokens -= float64(n)
+			b.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+		if b.changed == nil {
+			b.changed = make(chan struct{})
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-changed:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket_WaitN(t *testing.T) {
+	ctx := context.Background()
+	b := newTokenBucket(0)
+	// unlimited
+	for i := 0; i < 1000; i++ {
+		if err := b.WaitN(ctx, 1<<20); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	b.setRate(1000)
+	start := time.Now()
+	// burst of one second is available immediately, the rest waits
+	for i := 0; i < 15; i++ {
+		if err := b.WaitN(ctx, 100); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("rate limit not applied, elapsed %s", elapsed)
+		return
+	}
+
+	// change at runtime
+	b.setRate(0)
+	start = time.Now()
+	if err := b.WaitN(ctx, 1<<20); err != nil {
+		t.Error(err)
+		return
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("rate limit not removed, elapsed %s", elapsed)
+	}
+}
+
+func TestTokenBucket_WaitNCancel(t *testing.T) {
+	b := newTokenBucket(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	// the burst passes, the next wait is cancelled
+	if err := b.WaitN(ctx, 100); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := b.WaitN(ctx, 100); err == nil {
+		t.Error("expected context error")
+	}
+}
+
+func TestTokenBucket_SetRateWakesWaiters(t *testing.T) {
+	ctx := context.Background()
+	b := newTokenBucket(1)
+	if err := b.WaitN(ctx, 1); err != nil {
+		t.Error(err)
+		return
+	}
+	// the next token is one second away, a higher rate shortens the wait
+	done := make(chan time.Duration)
+	go func() {
+		start := time.Now()
+		_ = b.WaitN(ctx, 1)
+		done <- time.Since(start)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	b.setRate(1000)
+	if elapsed := <-done; elapsed > 500*time.Millisecond {
+		t.Errorf("waiter not woken by the new rate, elapsed %s", elapsed)
+	}
+
+	// a chunk larger than the rate passes once the bucket is full
+	b = newTokenBucket(10)
+	start := time.Now()
+	if err := b.WaitN(ctx, 32<<10); err != nil {
+		t.Error(err)
+		return
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("full bucket blocked a large chunk, elapsed %s", elapsed)
+	}
+	go func() {
+		start := time.Now()
+		_ = b.WaitN(ctx, 1)
+		done <- time.Since(start)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	b.setRate(0)
+	if elapsed := <-done; elapsed > 500*time.Millisecond {
+		t.Errorf("waiter not woken by removing the limit, elapsed %s", elapsed)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	ctx := context.Background()
+	// the zero value is unlimited
+	var l Limits
+	for _, b := range []*tokenBucket{&l.bandwidth, &l.locator, l.node("http://edge-a")} {
+		if err := b.WaitN(ctx, 1<<20); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	l.SetNodeBandwidth(1000)
+	a := l.node("http://edge-a")
+	if a.rate != 1000 || l.node("http://edge-b").rate != 1000 {
+		t.Error("node bandwidth not applied")
+	}
+	if l.node("http://edge-a") != a {
+		t.Error("node bucket not reused")
+	}
+	start := time.Now()
+	for i := 0; i < 15; i++ {
+		if err := a.WaitN(ctx, 100); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("node limit not applied, elapsed %s", elapsed)
+	}
+
+	// idle buckets are dropped
+	l.sweep(time.Now().Add(nodeIdleTimeout))
+	if len(l.nodes) != 0 {
+		t.Errorf("expected idle buckets dropped, got %d", len(l.nodes))
+	}
+	b := l.node("http://edge-b")
+	_ = b.WaitN(ctx, 1)
+	l.sweep(time.Now())
+	if len(l.nodes) != 1 {
+		t.Errorf("expected the used bucket kept, got %d", len(l.nodes))
+	}
+}
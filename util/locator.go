@@ -0,0 +1,115 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/linguohua/titan/api"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// locatorNamespace the JSON-RPC namespace of the titan api, as titan api/client
+const locatorNamespace = "titan"
+
+// maxLocatorResponse the largest locator response accepted
+const maxLocatorResponse = 16 << 20
+
+// locatorAPI the methods of api.Locator used by the fetcher
+type locatorAPI interface {
+	GetDownloadInfosWithCarfile(ctx context.Context, cid, publicKey string) ([]*api.DownloadInfoResult, error)
+	UserDownloadBlockResults(ctx context.Context, results []api.UserBlockDownloadResult) error
+}
+
+var _ locatorAPI = (api.Locator)(nil)
+var _ locatorAPI = (*rpcLocator)(nil)
+
+// rpcLocator a JSON-RPC 2.0 client of the locator over an injected http.Client.
+// the go-jsonrpc client of titan always uses its own http client, so a custom transport,
+// CA bundle or mTLS would not reach the locator
+type rpcLocator struct {
+	addr   string
+	client *http.Client
+	header http.Header
+	id     int64
+}
+
+func newRPCLocator(addr string, client *http.Client, header http.Header) *rpcLocator {
+	return &rpcLocator{addr: addr, client: client, header: header}
+}
+
+type rpcRequest struct {
+	Jsonrpc string        `json:"jsonrpc"`
+	ID      int64         `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("locator rpc error %d : %s", e.Code, e.Message)
+}
+
+func (l *rpcLocator) GetDownloadInfosWithCarfile(ctx context.Context, cid, publicKey string) ([]*api.DownloadInfoResult, error) {
+	var infos []*api.DownloadInfoResult
+	if err := l.call(ctx, "GetDownloadInfosWithCarfile", []interface{}{cid, publicKey}, &infos); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+func (l *rpcLocator) UserDownloadBlockResults(ctx context.Context, results []api.UserBlockDownloadResult) error {
+	return l.call(ctx, "UserDownloadBlockResults", []interface{}{results}, nil)
+}
+
+func (l *rpcLocator) call(ctx context.Context, method string, params []interface{}, result interface{}) error {
+	body, err := json.Marshal(&rpcRequest{
+		Jsonrpc: "2.0",
+		ID:      atomic.AddInt64(&l.id, 1),
+		Method:  locatorNamespace + "." + method,
+		Params:  params,
+	})
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, l.addr, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range l.header {
+		request.Header[k] = v
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	resp, err := l.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("locator rpc %s : %s", method, resp.Status)
+	}
+
+	var res rpcResponse
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxLocatorResponse)).Decode(&res); err != nil {
+		return fmt.Errorf("locator rpc %s : %w", method, err)
+	}
+	if res.Error != nil {
+		return res.Error
+	}
+	if result == nil || len(res.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(res.Result, result)
+}
@@ -0,0 +1,150 @@
+package util
+
+import (
+	"context"
+	"errors"
+	http2 "github.com/timtide/titan-client/util/http"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"time"
+)
+
+// metrics are recorded with OpenCensus, nothing is exported until the caller
+// registers the views and an OpenCensus exporter of its choice, eg:
+//
+//	view.Register(util.DefaultViews...)
+//	view.RegisterExporter(exporter)
+//
+// or reads them with view.RetrieveData
+
+// Source of a block
+const (
+	SourceEdge    = "edge"
+	SourceGateway = "gateway"
+	SourceCache   = "cache"
+)
+
+// Tags
+var (
+	// Source edge, gateway or cache
+	Source = tag.MustNewKey("source")
+	// ErrorType hash_mismatch, too_large, bad_sign, no_node, canceled or other
+	ErrorType = tag.MustNewKey("error_type")
+	// Success true or false
+	Success = tag.MustNewKey("success")
+)
+
+// Measures
+var (
+	BlocksFetched   = stats.Int64("titan/client/blocks", "Number of blocks fetched", stats.UnitDimensionless)
+	BytesFetched    = stats.Int64("titan/client/bytes", "Number of bytes fetched", stats.UnitBytes)
+	RequestLatency  = stats.Float64("titan/client/request_latency", "Latency of block requests to edge nodes and gateways", stats.UnitMilliseconds)
+	Errors          = stats.Int64("titan/client/errors", "Number of failed requests", stats.UnitDimensionless)
+	LocatorLatency  = stats.Float64("titan/client/locator_latency", "Latency of download info lookups", stats.UnitMilliseconds)
+	Callbacks       = stats.Int64("titan/client/callbacks", "Number of download results sent to locator", stats.UnitDimensionless)
+	ActiveDownloads = stats.Int64("titan/client/active_downloads", "Number of downloads in progress", stats.UnitDimensionless)
+)
+
+var defaultMillisecondsDistribution = view.Distribution(1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000, 30000)
+
+// Views
+var (
+	BlocksFetchedView = &view.View{
+		Measure:     BlocksFetched,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{Source},
+	}
+	BytesFetchedView = &view.View{
+		Measure:     BytesFetched,
+		Aggregation: view.Sum(),
+		TagKeys:     []tag.Key{Source},
+	}
+	RequestLatencyView = &view.View{
+		Measure:     RequestLatency,
+		Aggregation: defaultMillisecondsDistribution,
+		TagKeys:     []tag.Key{Source},
+	}
+	ErrorsView = &view.View{
+		Measure:     Errors,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{Source, ErrorType},
+	}
+	LocatorLatencyView = &view.View{
+		Measure:     LocatorLatency,
+		Aggregation: defaultMillisecondsDistribution,
+		TagKeys:     []tag.Key{Success},
+	}
+	CallbacksView = &view.View{
+		Measure:     Callbacks,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{Success},
+	}
+	ActiveDownloadsView = &view.View{
+		Measure:     ActiveDownloads,
+		Aggregation: view.Sum(),
+	}
+)
+
+// DefaultViews all views of the client
+var DefaultViews = []*view.View{
+	BlocksFetchedView,
+	BytesFetchedView,
+	RequestLatencyView,
+	ErrorsView,
+	LocatorLatencyView,
+	CallbacksView,
+	ActiveDownloadsView,
+}
+
+// sinceInMilliseconds the elapsed time for latency measures
+func sinceInMilliseconds(start time.Time) float64 {
+	return float64(time.Since(start).Nanoseconds()) / 1e6
+}
+
+// recordFetched a block was fetched from source
+func recordFetched(ctx context.Context, source string, size int, start time.Time) {
+	ms := []stats.Measurement{BlocksFetched.M(1), BytesFetched.M(int64(size))}
+	if source != SourceCache {
+		ms = append(ms, RequestLatency.M(sinceInMilliseconds(start)))
+	}
+	_ = stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(Source, source)}, ms...)
+}
+
+// recordError a request to source failed
+func recordError(ctx context.Context, source string, err error) {
+	_ = stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(Source, source), tag.Upsert(ErrorType, errorType(err))}, Errors.M(1))
+}
+
+func recordLocatorLookup(ctx context.Context, start time.Time, err error) {
+	_ = stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(Success, successValue(err == nil))}, LocatorLatency.M(sinceInMilliseconds(start)))
+}
+
+func recordCallback(ctx context.Context, success bool) {
+	_ = stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(Success, successValue(success))}, Callbacks.M(1))
+}
+
+func successValue(success bool) string {
+	if success {
+		return "true"
+	}
+	return "false"
+}
+
+func errorType(err error) string {
+	var tooLarge *http2.TooLargeError
+	switch {
+	case errors.Is(err, ErrHashMismatch):
+		return "hash_mismatch"
+	case errors.As(err, &tooLarge):
+		return "too_large"
+	case errors.Is(err, ErrBadDownloadInfoSign):
+		return "bad_sign"
+	case errors.Is(err, ErrNoAvailableNode):
+		return "no_node"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	default:
+		return "other"
+	}
+}
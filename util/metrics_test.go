@@ -0,0 +1,88 @@
+package util
+
+import (
+	"context"
+	"github.com/ipfs/go-cid"
+	"github.com/linguohua/titan/api"
+	"go.opencensus.io/stats/view"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// viewValues the value of every row of the view by source tag, sum or count
+func viewValues(t *testing.T, v *view.View) map[string]float64 {
+	rows, err := view.RetrieveData(v.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]float64)
+	for _, row := range rows {
+		source := ""
+		for _, tg := range row.Tags {
+			if tg.Key == Source {
+				source = tg.Value
+			}
+		}
+		switch data := row.Data.(type) {
+		case *view.SumData:
+			values[source] = data.Value
+		case *view.CountData:
+			values[source] = float64(data.Value)
+		}
+	}
+	return values
+}
+
+func TestMetrics(t *testing.T) {
+	if err := view.Register(DefaultViews...); err != nil {
+		t.Error(err)
+		return
+	}
+	defer view.Unregister(DefaultViews...)
+
+	block := []byte("hello titan !!!")
+	c, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: 0x12, MhLength: -1}.Sum(block)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(block)
+	}))
+	defer srv.Close()
+	locator := &fakeLocator{nodes: []*api.DownloadInfoResult{{URL: srv.URL, Sign: "sign", SN: 1}}}
+	f := NewFetcher(WithLocatorAddressOption("http://127.0.0.1:5000"), WithBlockCacheOption(NewBlockCache(1<<20))).(*fetcher)
+	f.newLocator = func(ctx context.Context, addr string, header http.Header) (locatorAPI, func(), error) {
+		return locator, func() {}, nil
+	}
+
+	// from the edge node, then from the cache twice
+	for i := 0; i < 3; i++ {
+		if _, err = f.GetBlockData(context.Background(), c); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	bytes := viewValues(t, BytesFetchedView)
+	if bytes[SourceEdge] != float64(len(block)) || bytes[SourceCache] != float64(2*len(block)) {
+		t.Errorf("unexpected bytes per source %v", bytes)
+	}
+	blocks := viewValues(t, BlocksFetchedView)
+	if blocks[SourceEdge] != 1 || blocks[SourceCache] != 2 {
+		t.Errorf("unexpected blocks per source %v", blocks)
+	}
+
+	// the errors are tagged with their type
+	recordError(context.Background(), SourceGateway, ErrHashMismatch)
+	rows, err := view.RetrieveData(ErrorsView.Name)
+	if err != nil || len(rows) != 1 {
+		t.Errorf("unexpected error rows %v: %v", rows, err)
+		return
+	}
+	for _, tg := range rows[0].Tags {
+		if tg.Key == ErrorType && tg.Value != "hash_mismatch" {
+			t.Errorf("unexpected error type %s", tg.Value)
+		}
+	}
+}
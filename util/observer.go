@@ -0,0 +1,82 @@
+package util
+
+import (
+	"github.com/ipfs/go-cid"
+	"time"
+)
+
+// Observer receives the events of the block download lifecycle, eg: to update a UI,
+// audit or bill. methods are called synchronously from the download goroutines,
+// they must return quickly and be safe for concurrent use.
+// embed NopObserver to implement only some of them
+type Observer interface {
+	// LocatorLookupDone locator returned nodes edge nodes of the carfile root
+	LocatorLookupDone(root cid.Cid, nodes int, duration time.Duration, err error)
+	// BlockFetched block c of size bytes was fetched from source,
+	// node is the edge node url or gateway address, empty for the cache
+	BlockFetched(c cid.Cid, size int, source string, node string, duration time.Duration)
+	// NodeFailed the download of block c from the edge node failed
+	NodeFailed(c cid.Cid, node string, err error)
+	// GatewayFallback block c is requested from the gateway, reason is the error of titan
+	GatewayFallback(c cid.Cid, gateway string, reason error)
+	// CallbackSent the download result of block c was reported to locator
+	CallbackSent(c cid.Cid, sn int64, downloadSuccess bool, err error)
+	// DownloadComplete the download of root to outPath finished
+	DownloadComplete(root cid.Cid, outPath string, duration time.Duration, err error)
+}
+
+// NopObserver ignores all events
+type NopObserver struct{}
+
+func (NopObserver) LocatorLookupDone(root cid.Cid, nodes int, duration time.Duration, err error) {}
+
+func (NopObserver) BlockFetched(c cid.Cid, size int, source string, node string, duration time.Duration) {
+}
+
+func (NopObserver) NodeFailed(c cid.Cid, node string, err error) {}
+
+func (NopObserver) GatewayFallback(c cid.Cid, gateway string, reason error) {}
+
+func (NopObserver) CallbackSent(c cid.Cid, sn int64, downloadSuccess bool, err error) {}
+
+func (NopObserver) DownloadComplete(root cid.Cid, outPath string, duration time.Duration, err error) {
+}
+
+// Observers dispatch every event to all observers in order
+type Observers []Observer
+
+func (obs Observers) LocatorLookupDone(root cid.Cid, nodes int, duration time.Duration, err error) {
+	for _, o := range obs {
+		o.LocatorLookupDone(root, nodes, duration, err)
+	}
+}
+
+func (obs Observers) BlockFetched(c cid.Cid, size int, source string, node string, duration time.Duration) {
+	for _, o := range obs {
+		o.BlockFetched(c, size, source, node, duration)
+	}
+}
+
+func (obs Observers) NodeFailed(c cid.Cid, node string, err error) {
+	for _, o := range obs {
+		o.NodeFailed(c, node, err)
+	}
+}
+
+func (obs Observers) GatewayFallback(c cid.Cid, gateway string, reason error) {
+	for _, o := range obs {
+		o.GatewayFallback(c, gateway, reason)
+	}
+}
+
+func (obs Observers) CallbackSent(c cid.Cid, sn int64, downloadSuccess bool, err error) {
+	for _, o := range obs {
+		o.CallbackSent(c, sn, downloadSuccess, err)
+	}
+}
+
+func (obs Observers) DownloadComplete(root cid.Cid, outPath string, duration time.Duration, err error) {
+	for _, o := range obs {
+		o.DownloadComplete(root, outPath, duration, err)
+	}
+}
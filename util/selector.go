@@ -0,0 +1,305 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"github.com/ipfs/go-cid"
+	"github.com/linguohua/titan/api"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Selector the strategy picking the edge node of every block download.
+// implementations must be safe for concurrent use
+type Selector interface {
+	// Select returns one of nodes to download block c, nodes is never empty
+	// and contains only the nodes that are not blacklisted
+	Select(c cid.Cid, nodes []*api.DownloadInfoResult) *api.DownloadInfoResult
+	// Done is called after the download from node selected by Select,
+	// err is nil on success
+	Done(node *api.DownloadInfoResult, err error)
+}
+
+// lockedRand a private source of randomness safe for concurrent use
+type lockedRand struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// newLockedRand src nil means a source seeded with the current time
+func newLockedRand(src rand.Source) *lockedRand {
+	if src == nil {
+		src = rand.NewSource(time.Now().UnixNano())
+	}
+	return &lockedRand{rnd: rand.New(src)}
+}
+
+func (l *lockedRand) Intn(n int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rnd.Intn(n)
+}
+
+func (l *lockedRand) Int63() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rnd.Int63()
+}
+
+// weightScale the weights of the download infos are scaled, so a failing node
+// can be down-weighted several times before it reaches the minimum
+const weightScale = 64
+
+// maxChooserSets the download info sets kept by the weighted selector, beyond all are dropped
+const maxChooserSets = 256
+
+type weightedSelector struct {
+	rnd *lockedRand
+
+	mu sync.Mutex
+	// the Chooser of the download info set of every node, one per locator lookup
+	choosers map[*api.DownloadInfoResult]*weightedSet
+}
+
+// weightedSet the Chooser of one download info set, with the base weights
+type weightedSet struct {
+	chooser *Chooser
+	base    map[string]int
+}
+
+// NewWeightedSelector picks by the weight of the download info,
+// uniform random if no node has a weight. this is the default strategy.
+// a node is down-weighted on every failure and restored on success,
+// a node which returned bad data is never picked again.
+// src nil means a source seeded with the current time
+func NewWeightedSelector(src rand.Source) Selector {
+	return &weightedSelector{rnd: newLockedRand(src), choosers: make(map[*api.DownloadInfoResult]*weightedSet)}
+}
+
+func (s *weightedSelector) Select(c cid.Cid, nodes []*api.DownloadInfoResult) *api.DownloadInfoResult {
+	if len(nodes) == 1 {
+		return nodes[0]
+	}
+	set := s.set(nodes)
+	if set != nil {
+		if node := set.chooser.Pick(); node != nil {
+			return node
+		}
+	}
+	return nodes[s.rnd.Intn(len(nodes))]
+}
+
+// set the Chooser of nodes, built on the first Select of a download info set.
+// nodes missing from a known set were removed by the caller, eg: blacklisted
+func (s *weightedSelector) set(nodes []*api.DownloadInfoResult) *weightedSet {
+	s.mu.Lock()
+	set, ok := s.choosers[nodes[0]]
+	s.mu.Unlock()
+	if ok {
+		if set.chooser.size() != len(nodes) {
+			set.retain(nodes)
+		}
+		return set
+	}
+
+	uniform := true
+	for _, v := range nodes {
+		if v.Weight > 0 {
+			uniform = false
+			break
+		}
+	}
+	set = &weightedSet{base: make(map[string]int, len(nodes))}
+	weights := make([]int, len(nodes))
+	for i, v := range nodes {
+		weights[i] = v.Weight * weightScale
+		if uniform {
+			weights[i] = weightScale
+		}
+		set.base[v.URL] = weights[i]
+	}
+	chooser, err := newChooser(rand.NewSource(s.rnd.Int63()), nodes, weights)
+	if err != nil {
+		return nil
+	}
+	set.chooser = chooser
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.choosers) >= maxChooserSets {
+		s.choosers = make(map[*api.DownloadInfoResult]*weightedSet)
+	}
+	for _, v := range nodes {
+		s.choosers[v] = set
+	}
+	return set
+}
+
+// retain remove the nodes of the Chooser that are not in nodes
+func (set *weightedSet) retain(nodes []*api.DownloadInfoResult) {
+	keep := make(map[string]bool, len(nodes))
+	for _, v := range nodes {
+		keep[v.URL] = true
+	}
+	for url := range set.base {
+		if !keep[url] {
+			_ = set.chooser.Remove(url)
+		}
+	}
+}
+
+func (s *weightedSelector) Done(node *api.DownloadInfoResult, err error) {
+	s.mu.Lock()
+	set, ok := s.choosers[node]
+	s.mu.Unlock()
+	if !ok || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+	weight, ok := set.chooser.Weight(node.URL)
+	if !ok || set.base[node.URL] <= 0 {
+		return
+	}
+	switch {
+	case errors.Is(err, ErrHashMismatch):
+		_ = set.chooser.Remove(node.URL)
+		return
+	case err != nil:
+		// halve, keep the minimum so that the node is retried once in a while
+		weight /= 2
+		if weight < 1 {
+			weight = 1
+		}
+	default:
+		weight *= 2
+		if base := set.base[node.URL]; weight > base {
+			weight = base
+		}
+	}
+	_ = set.chooser.Update(node.URL, weight)
+}
+
+type roundRobinSelector struct {
+	next uint64
+}
+
+// NewRoundRobinSelector picks the nodes in turn
+func NewRoundRobinSelector() Selector {
+	return &roundRobinSelector{}
+}
+
+func (s *roundRobinSelector) Select(c cid.Cid, nodes []*api.DownloadInfoResult) *api.DownloadInfoResult {
+	n := atomic.AddUint64(&s.next, 1) - 1
+	return nodes[n%uint64(len(nodes))]
+}
+
+func (s *roundRobinSelector) Done(node *api.DownloadInfoResult, err error) {}
+
+// outstanding counts the requests in flight of every node
+type outstanding struct {
+	mu       sync.Mutex
+	inflight map[string]int
+}
+
+func (o *outstanding) get(url string) int {
+	return o.inflight[url]
+}
+
+func (o *outstanding) acquire(node *api.DownloadInfoResult) *api.DownloadInfoResult {
+	o.inflight[node.URL]++
+	return node
+}
+
+func (o *outstanding) Done(node *api.DownloadInfoResult, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.inflight[node.URL] <= 1 {
+		delete(o.inflight, node.URL)
+		return
+	}
+	o.inflight[node.URL]--
+}
+
+type leastOutstandingSelector struct {
+	outstanding
+}
+
+// NewLeastOutstandingSelector picks the node with the fewest requests in flight
+func NewLeastOutstandingSelector() Selector {
+	return &leastOutstandingSelector{outstanding{inflight: make(map[string]int)}}
+}
+
+func (s *leastOutstandingSelector) Select(c cid.Cid, nodes []*api.DownloadInfoResult) *api.DownloadInfoResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	best := nodes[0]
+	for _, v := range nodes[1:] {
+		if s.get(v.URL) < s.get(best.URL) {
+			best = v
+		}
+	}
+	return s.acquire(best)
+}
+
+type powerOfTwoSelector struct {
+	outstanding
+	rnd *lockedRand
+}
+
+// NewPowerOfTwoSelector picks two random nodes and uses the one with fewer requests in flight.
+// src nil means a source seeded with the current time
+func NewPowerOfTwoSelector(src rand.Source) Selector {
+	return &powerOfTwoSelector{
+		outstanding: outstanding{inflight: make(map[string]int)},
+		rnd:         newLockedRand(src),
+	}
+}
+
+func (s *powerOfTwoSelector) Select(c cid.Cid, nodes []*api.DownloadInfoResult) *api.DownloadInfoResult {
+	first := nodes[s.rnd.Intn(len(nodes))]
+	second := nodes[s.rnd.Intn(len(nodes))]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.get(second.URL) < s.get(first.URL) {
+		return s.acquire(second)
+	}
+	return s.acquire(first)
+}
+
+type consistentHashSelector struct{}
+
+// NewConsistentHashSelector picks the node by the block cid, so the same block
+// always hits the same node and benefits from its cache. uses rendezvous hashing,
+// when a node is blacklisted only its blocks move to other nodes
+func NewConsistentHashSelector() Selector {
+	return consistentHashSelector{}
+}
+
+func (s consistentHashSelector) Select(c cid.Cid, nodes []*api.DownloadInfoResult) *api.DownloadInfoResult {
+	var best *api.DownloadInfoResult
+	var bestScore uint64
+	for _, v := range nodes {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(v.URL))
+		_, _ = h.Write(c.Bytes())
+		score := mix64(h.Sum64())
+		if best == nil || score > bestScore {
+			best, bestScore = v, score
+		}
+	}
+	return best
+}
+
+func (s consistentHashSelector) Done(node *api.DownloadInfoResult, err error) {}
+
+// mix64 spread the fnv hash bits, fnv alone distributes similar inputs poorly
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
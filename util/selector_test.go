@@ -0,0 +1,157 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"github.com/ipfs/go-cid"
+	"github.com/linguohua/titan/api"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestSelectors(t *testing.T) {
+	c, err := cid.Decode("QmUbaDBz6YKn3dVzoKrLDyupMmyWk5am2QSdgfKsU1RN3N")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	selectors := map[string]Selector{
+		"weighted":          NewWeightedSelector(rand.NewSource(1)),
+		"round-robin":       NewRoundRobinSelector(),
+		"least-outstanding": NewLeastOutstandingSelector(),
+		"power-of-two":      NewPowerOfTwoSelector(rand.NewSource(1)),
+		"consistent-hash":   NewConsistentHashSelector(),
+	}
+	// expected share of every node, all nodes idle
+	shares := map[string]map[string]float64{
+		"weighted":          {"first": 0.1, "two": 0.2, "three": 0.3, "four": 0.4},
+		"round-robin":       {"first": 0.25, "two": 0.25, "three": 0.25, "four": 0.25},
+		"least-outstanding": {"first": 1},
+		"power-of-two":      {"first": 0.25, "two": 0.25, "three": 0.25, "four": 0.25},
+	}
+	for name, s := range selectors {
+		res := make(map[string]int)
+		for i := 0; i < 10000; i++ {
+			node := s.Select(c, testData)
+			res[node.URL]++
+			s.Done(node, nil)
+		}
+		t.Logf("%s : %v", name, res)
+		if name == "consistent-hash" {
+			if len(res) != 1 {
+				t.Errorf("consistent hash spread one block over %d nodes", len(res))
+			}
+			continue
+		}
+		for _, v := range testData {
+			if share := float64(res[v.URL]) / 10000; math.Abs(share-shares[name][v.URL]) > 0.03 {
+				t.Errorf("%s picked %s %.3f of the time, expected %.3f", name, v.URL, share, shares[name][v.URL])
+			}
+		}
+	}
+
+	// round robin visits every node in turn
+	rr := NewRoundRobinSelector()
+	for i := 0; i < 2*len(testData); i++ {
+		if node := rr.Select(c, testData); node != testData[i%len(testData)] {
+			t.Errorf("round robin picked %s at %d", node.URL, i)
+			return
+		}
+	}
+
+	// least outstanding avoids the busy nodes
+	lo := NewLeastOutstandingSelector()
+	busy := make(map[string]bool)
+	for i := 0; i < len(testData); i++ {
+		node := lo.Select(c, testData)
+		if busy[node.URL] {
+			t.Errorf("least outstanding picked busy node %s", node.URL)
+			return
+		}
+		busy[node.URL] = true
+	}
+
+	// consistent hash is stable and moves the block off a removed node
+	ch := NewConsistentHashSelector()
+	node := ch.Select(c, testData)
+	others := make([]*api.DownloadInfoResult, 0, len(testData))
+	for _, v := range testData {
+		if v != node {
+			others = append(others, v)
+		}
+	}
+	if ch.Select(c, testData) != node {
+		t.Error("consistent hash picked another node for the same block")
+		return
+	}
+	if next := ch.Select(c, others); next == node {
+		t.Error("consistent hash picked a removed node")
+	}
+}
+
+func TestWeightedSelectorFailures(t *testing.T) {
+	c, _ := cid.Decode("QmUbaDBz6YKn3dVzoKrLDyupMmyWk5am2QSdgfKsU1RN3N")
+	nodes := []*api.DownloadInfoResult{{URL: "a", Weight: 1}, {URL: "b", Weight: 1}}
+	s := NewWeightedSelector(rand.NewSource(1))
+	share := func(url string) float64 {
+		n := 0
+		for i := 0; i < 10000; i++ {
+			if s.Select(c, nodes).URL == url {
+				n++
+			}
+		}
+		return float64(n) / 10000
+	}
+	if v := share("a"); math.Abs(v-0.5) > 0.03 {
+		t.Errorf("a picked %.3f of the time, expected 0.5", v)
+	}
+
+	// a failing node is down-weighted, 64 -> 16 against 64
+	s.Done(nodes[0], errors.New("timeout"))
+	s.Done(nodes[0], errors.New("timeout"))
+	if v := share("a"); math.Abs(v-0.2) > 0.03 {
+		t.Errorf("failing a picked %.3f of the time, expected 0.2", v)
+	}
+	// a cancelled download says nothing about the node
+	s.Done(nodes[0], context.Canceled)
+	if v := share("a"); math.Abs(v-0.2) > 0.03 {
+		t.Errorf("cancel changed the weight of a, picked %.3f of the time", v)
+	}
+	// and restored on success
+	s.Done(nodes[0], nil)
+	s.Done(nodes[0], nil)
+	if v := share("a"); math.Abs(v-0.5) > 0.03 {
+		t.Errorf("restored a picked %.3f of the time, expected 0.5", v)
+	}
+
+	// bad data removes the node, also when the caller still offers it
+	s.Done(nodes[1], ErrHashMismatch)
+	if v := share("b"); v != 0 {
+		t.Errorf("node with bad data picked %.3f of the time", v)
+	}
+}
+
+func TestPowerOfTwoSelectorBusy(t *testing.T) {
+	c, _ := cid.Decode("QmUbaDBz6YKn3dVzoKrLDyupMmyWk5am2QSdgfKsU1RN3N")
+	nodes := []*api.DownloadInfoResult{{URL: "busy"}, {URL: "idle"}}
+	s := NewPowerOfTwoSelector(rand.NewSource(1))
+	// keep busy with requests in flight
+	for s.(*powerOfTwoSelector).get("busy") < 5 {
+		if node := s.Select(c, nodes); node.URL != "busy" {
+			s.Done(node, nil)
+		}
+	}
+	busy := 0
+	for i := 0; i < 10000; i++ {
+		node := s.Select(c, nodes)
+		if node.URL == "busy" {
+			busy++
+		}
+		s.Done(node, nil)
+	}
+	// busy is only used when both random picks are busy, a quarter of the time
+	if share := float64(busy) / 10000; math.Abs(share-0.25) > 0.03 {
+		t.Errorf("busy node picked %.3f of the time, expected 0.25", share)
+	}
+}
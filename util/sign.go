@@ -2,45 +2,365 @@ package util
 
 import (
 	"crypto"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"sync"
 )
 
-var rs *rsaSign
+// DefaultRSAKeyBits the key size of the identity generated when none is set
+const DefaultRSAKeyBits = 2048
+
+// minRSAKeyBits keys below are refused, below the security baseline
+const minRSAKeyBits = 2048
+
+var rs Signer
 var one sync.Once
+var rsLock sync.RWMutex
 
-func init() {
+// GetSigner returns the identity of the process,
+// a RSA-2048 key is generated on first use if none was set by SetSigner
+func GetSigner() Signer {
 	one.Do(
 		func() {
-			rs = new(rsaSign)
-			err := rs.generateRSAKey(1024)
+			rsLock.Lock()
+			defer rsLock.Unlock()
+			if rs != nil {
+				return
+			}
+			s, err := NewRSASigner(DefaultRSAKeyBits)
 			if err != nil {
 				panic(err)
 			}
+			rs = s
 		},
 	)
+	rsLock.RLock()
+	defer rsLock.RUnlock()
+	return rs
 }
 
-func GetSigner() Signer {
-	return rs
+// SetSigner replace the identity of the process, eg: loaded by LoadSignerFile
+func SetSigner(s Signer) {
+	rsLock.Lock()
+	defer rsLock.Unlock()
+	rs = s
 }
 
+// Signer the identity of the client, a RSA key: locator receives its
+// public key as PKCS1 "RSA PUBLIC KEY" and checks RSA PKCS1v15 signatures
 type Signer interface {
 	Sign(msg []byte) ([]byte, error)
 	VerifySign(msg []byte, sign []byte) bool
 	GetPublicKey() rsa.PublicKey
 }
 
+// KeySigner the identity of the client of any key type, eg: Ed25519.
+// locator receives its public key by MarshalPublicKeyPem, PKIX "PUBLIC KEY" if not RSA,
+// and must accept that key type. the signers of this package implement it
+type KeySigner interface {
+	Sign(msg []byte) ([]byte, error)
+	VerifySign(msg []byte, sign []byte) bool
+	PublicKey() crypto.PublicKey
+}
+
+// AsKeySigner s as a KeySigner
+func AsKeySigner(s Signer) KeySigner {
+	if ks, ok := s.(KeySigner); ok {
+		return ks
+	}
+	return rsaKeySigner{Signer: s}
+}
+
+// rsaKeySigner a Signer implemented outside this package, eg: backed by an HSM
+type rsaKeySigner struct {
+	Signer
+}
+
+func (r rsaKeySigner) PublicKey() crypto.PublicKey {
+	publicKey := r.GetPublicKey()
+	return &publicKey
+}
+
+// NewEd25519Signer generate an Ed25519 key
+func NewEd25519Signer() (KeySigner, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &ed25519Sign{privateKey: privateKey}, nil
+}
+
+// NewRSASigner generate a RSA key, bits at least 2048, eg: 2048 or 4096
+func NewRSASigner(bits int) (Signer, error) {
+	if bits < minRSAKeyBits {
+		return nil, fmt.Errorf("rsa key size %d is below %d bits", bits, minRSAKeyBits)
+	}
+	r := new(rsaSign)
+	if err := r.generateRSAKey(bits); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// LoadSigner parse a PEM encoded RSA private key,
+// PKCS1 "RSA PRIVATE KEY" or PKCS8 "PRIVATE KEY"
+func LoadSigner(pemBytes []byte) (Signer, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no pem block found")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return newRSASignWithKey(privateKey)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		k, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T, a Signer is RSA, see LoadKeySigner", key)
+		}
+		return newRSASignWithKey(k)
+	default:
+		return nil, fmt.Errorf("unsupported pem block type %s", block.Type)
+	}
+}
+
+// LoadKeySigner parse a PEM encoded RSA or Ed25519 private key,
+// PKCS1 "RSA PRIVATE KEY" or PKCS8 "PRIVATE KEY"
+func LoadKeySigner(pemBytes []byte) (KeySigner, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no pem block found")
+	}
+	if block.Type == "PRIVATE KEY" {
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if k, ok := key.(ed25519.PrivateKey); ok {
+			return &ed25519Sign{privateKey: k}, nil
+		}
+	}
+	s, err := LoadSigner(pemBytes)
+	if err != nil {
+		return nil, err
+	}
+	return AsKeySigner(s), nil
+}
+
+// LoadKeySignerFile read a PEM encoded RSA or Ed25519 private key from file
+func LoadKeySignerFile(path string) (KeySigner, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return LoadKeySigner(pemBytes)
+}
+
+// LoadSignerFile read a PEM encoded private key from file
+func LoadSignerFile(path string) (Signer, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return LoadSigner(pemBytes)
+}
+
+// LoadOrGenerateKeySignerFile load the identity from file as LoadKeySignerFile,
+// if the file does not exist, generate one by generate and save it
+func LoadOrGenerateKeySignerFile(path string, generate func() (KeySigner, error)) (KeySigner, error) {
+	s, err := LoadKeySignerFile(path)
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return s, err
+	}
+	s, err = generate()
+	if err != nil {
+		return nil, err
+	}
+	if err = SaveKeySignerFile(s, path); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// LoadOrGenerateSignerFile load the identity from file,
+// if the file does not exist, generate one by generate and save it,
+// generate nil means a RSA key of DefaultRSAKeyBits
+func LoadOrGenerateSignerFile(path string, generate func() (Signer, error)) (Signer, error) {
+	s, err := LoadSignerFile(path)
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return s, err
+	}
+	if generate == nil {
+		generate = func() (Signer, error) {
+			return NewRSASigner(DefaultRSAKeyBits)
+		}
+	}
+	s, err = generate()
+	if err != nil {
+		return nil, err
+	}
+	if err = SaveSignerFile(s, path); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// MarshalSigner PEM encode the private key of a signer created by this package
+func MarshalSigner(s Signer) ([]byte, error) {
+	return marshalPrivateKey(s)
+}
+
+// MarshalKeySigner PEM encode the private key of a signer created by this package,
+// RSA as PKCS1 "RSA PRIVATE KEY", Ed25519 as PKCS8 "PRIVATE KEY"
+func MarshalKeySigner(s KeySigner) ([]byte, error) {
+	return marshalPrivateKey(s)
+}
+
+func marshalPrivateKey(s interface{}) ([]byte, error) {
+	switch v := s.(type) {
+	case *rsaSign:
+		return pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(v.privateKey),
+		}), nil
+	case *ed25519Sign:
+		der, err := x509.MarshalPKCS8PrivateKey(v.privateKey)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	default:
+		return nil, fmt.Errorf("can not marshal signer of type %T", s)
+	}
+}
+
+// SaveSignerFile write the PEM encoded private key to file, readable by the owner only.
+// the key is written to a temporary file renamed into place, an existing file is replaced
+func SaveSignerFile(s Signer, path string) error {
+	pemBytes, err := MarshalSigner(s)
+	if err != nil {
+		return err
+	}
+	return writeKeyFile(pemBytes, path)
+}
+
+// SaveKeySignerFile write the PEM encoded private key to file as SaveSignerFile
+func SaveKeySignerFile(s KeySigner, path string) error {
+	pemBytes, err := MarshalKeySigner(s)
+	if err != nil {
+		return err
+	}
+	return writeKeyFile(pemBytes, path)
+}
+
+// writeKeyFile write pemBytes to path atomically with mode 0600
+func writeKeyFile(pemBytes []byte, path string) error {
+	// CreateTemp creates the file with mode 0600
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	if _, err = f.Write(pemBytes); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Chmod(0600); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// MarshalPublicKeyPem PEM encode the public key sent to locator,
+// RSA as PKCS1 "RSA PUBLIC KEY", others as PKIX "PUBLIC KEY"
+func MarshalPublicKeyPem(publicKey crypto.PublicKey) ([]byte, error) {
+	if pk, ok := publicKey.(*rsa.PublicKey); ok {
+		return pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PUBLIC KEY",
+			Bytes: x509.MarshalPKCS1PublicKey(pk),
+		}), nil
+	}
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// ParsePublicKeyPem parse a PEM encoded public key,
+// PKCS1 "RSA PUBLIC KEY" or PKIX "PUBLIC KEY" of RSA or Ed25519
+func ParsePublicKeyPem(pemBytes []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no pem block found")
+	}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported pem block type %s", block.Type)
+	}
+}
+
+// VerifyWithPublicKey verify a signature, eg: of the scheduler or of a Signer,
+// RSA PKCS1v15 over sha256 or Ed25519
+func VerifyWithPublicKey(publicKey crypto.PublicKey, msg []byte, sign []byte) bool {
+	switch pk := publicKey.(type) {
+	case *rsa.PublicKey:
+		hash := sha256.Sum256(msg)
+		return rsa.VerifyPKCS1v15(pk, crypto.SHA256, hash[:], sign) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(pk, msg, sign)
+	default:
+		return false
+	}
+}
+
 type rsaSign struct {
 	privateKey *rsa.PrivateKey
 }
 
+func newRSASignWithKey(privateKey *rsa.PrivateKey) (*rsaSign, error) {
+	if privateKey.N.BitLen() < minRSAKeyBits {
+		return nil, fmt.Errorf("rsa key size %d is below %d bits", privateKey.N.BitLen(), minRSAKeyBits)
+	}
+	return &rsaSign{privateKey: privateKey}, nil
+}
+
 func (r *rsaSign) GetPublicKey() rsa.PublicKey {
 	return r.privateKey.PublicKey
 }
 
+func (r *rsaSign) PublicKey() crypto.PublicKey {
+	return &r.privateKey.PublicKey
+}
+
 func (r *rsaSign) Sign(msg []byte) ([]byte, error) {
 	hash := sha256.New()
 	hash.Write(msg)
@@ -64,3 +384,19 @@ func (r *rsaSign) generateRSAKey(bits int) error {
 	r.privateKey = privateKey
 	return nil
 }
+
+type ed25519Sign struct {
+	privateKey ed25519.PrivateKey
+}
+
+func (e *ed25519Sign) PublicKey() crypto.PublicKey {
+	return e.privateKey.Public()
+}
+
+func (e *ed25519Sign) Sign(msg []byte) ([]byte, error) {
+	return ed25519.Sign(e.privateKey, msg), nil
+}
+
+func (e *ed25519Sign) VerifySign(msg []byte, sign []byte) bool {
+	return ed25519.Verify(e.privateKey.Public().(ed25519.PublicKey), msg, sign)
+}
@@ -2,12 +2,15 @@ package util
 
 import (
 	"crypto"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/pem"
 	"github.com/ipfs/go-cid"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -81,3 +84,141 @@ func TestVerifySignByPublicKey(t *testing.T) {
 	}
 	t.Log("success")
 }
+
+func TestLoadSigner(t *testing.T) {
+	generators := map[string]func() (Signer, error){
+		"rsa-2048": func() (Signer, error) { return NewRSASigner(2048) },
+		"rsa-3072": func() (Signer, error) { return NewRSASigner(3072) },
+	}
+	msg := []byte(data)
+	for name, generate := range generators {
+		path := filepath.Join(t.TempDir(), name+".pem")
+		signer, err := LoadOrGenerateSignerFile(path, generate)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		// the second call loads the saved identity
+		loaded, err := LoadOrGenerateSignerFile(path, generate)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		signData, err := loaded.Sign(msg)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !signer.VerifySign(msg, signData) {
+			t.Errorf("[%s] loaded identity differs from the saved one", name)
+			return
+		}
+		publicKey := loaded.GetPublicKey()
+		publicKeyPem, err := MarshalPublicKeyPem(&publicKey)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		t.Log(string(publicKeyPem))
+	}
+
+	if _, err := NewRSASigner(1024); err == nil {
+		t.Error("rsa-1024 must be refused")
+	}
+
+	// a Signer is RSA, an Ed25519 key is a KeySigner
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	edPem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if _, err := LoadSigner(edPem); err == nil {
+		t.Error("ed25519 Signer must be refused")
+	}
+	ks, err := LoadKeySigner(edPem)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if pk, ok := ks.PublicKey().(ed25519.PublicKey); !ok || !pk.Equal(edKey.Public()) {
+		t.Errorf("unexpected public key %T", ks.PublicKey())
+	}
+}
+
+func TestKeySigner(t *testing.T) {
+	generators := map[string]func() (KeySigner, error){
+		"ed25519": NewEd25519Signer,
+		"rsa-2048": func() (KeySigner, error) {
+			s, err := NewRSASigner(2048)
+			if err != nil {
+				return nil, err
+			}
+			return AsKeySigner(s), nil
+		},
+	}
+	msg := []byte(data)
+	for name, generate := range generators {
+		path := filepath.Join(t.TempDir(), name+".pem")
+		signer, err := LoadOrGenerateKeySignerFile(path, generate)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+			t.Errorf("[%s] unexpected identity file mode %v: %v", name, info, err)
+		}
+		loaded, err := LoadOrGenerateKeySignerFile(path, generate)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		signData, err := loaded.Sign(msg)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !signer.VerifySign(msg, signData) {
+			t.Errorf("[%s] loaded identity differs from the saved one", name)
+		}
+
+		// locator verifies with the public key it received
+		publicKeyPem, err := MarshalPublicKeyPem(loaded.PublicKey())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		publicKey, err := ParsePublicKeyPem(publicKeyPem)
+		if err != nil || !VerifyWithPublicKey(publicKey, msg, signData) {
+			t.Errorf("[%s] signature not verified with the sent public key: %v", name, err)
+		}
+	}
+}
+
+func TestSaveSignerFile(t *testing.T) {
+	signer, err := NewRSASigner(2048)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// an existing world readable file gets the owner only permissions
+	path := filepath.Join(t.TempDir(), "identity.pem")
+	if err = os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+	if err = SaveSignerFile(signer, path); err != nil {
+		t.Error(err)
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("unexpected identity file mode %v: %v", info, err)
+		return
+	}
+	loaded, err := LoadSignerFile(path)
+	if err != nil || loaded.GetPublicKey().N.Cmp(signer.GetPublicKey().N) != 0 {
+		t.Errorf("saved identity not loaded: %v", err)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("temporary file left behind: %d entries", len(entries))
+	}
+}
@@ -0,0 +1,40 @@
+package util
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+// spans are created with the global TracerProvider and the trace context is
+// propagated with the global TextMapPropagator, both are no-op until the
+// application sets them with otel.SetTracerProvider and otel.SetTextMapPropagator
+var tracer = otel.Tracer("github.com/timtide/titan-client/util")
+
+// endSpan record the error if any and end the span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// detachedContext keeps the trace of ctx without its cancellation,
+// for work that outlives the request, eg: callbacks
+func detachedContext(ctx context.Context) context.Context {
+	return trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx))
+}
+
+// locatorHeader the extra headers with the trace context of ctx
+func (d *fetcher) locatorHeader(ctx context.Context) http.Header {
+	header := d.header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+	return header
+}
@@ -0,0 +1,51 @@
+package util
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"github.com/ipfs/go-cid"
+	"github.com/linguohua/titan/api"
+)
+
+// ErrHashMismatch the block data does not match the multihash of its cid,
+// the data source is corrupted or malicious
+var ErrHashMismatch = errors.New("block data does not match cid hash")
+
+// ErrBadDownloadInfoSign the download info was not signed by the scheduler,
+// forged or tampered by a compromised locator or MITM
+var ErrBadDownloadInfoSign = errors.New("download info sign verify fail")
+
+// DownloadInfoSignature returns the message the scheduler signed for a download info
+// and the signature decoded from its Sign. the titan api does not document either,
+// so they come from the deployment of the scheduler
+type DownloadInfoSignature func(di *api.DownloadInfoResult) (message, sign []byte, err error)
+
+// verifyDownloadInfo check the signature of the download info with the scheduler public key
+func verifyDownloadInfo(schedulerKey crypto.PublicKey, di *api.DownloadInfoResult, signature DownloadInfoSignature) error {
+	if signature == nil {
+		return fmt.Errorf("%w : no DownloadInfoSignature", ErrBadDownloadInfoSign)
+	}
+	message, sign, err := signature(di)
+	if err != nil {
+		return fmt.Errorf("%w : %s", ErrBadDownloadInfoSign, err.Error())
+	}
+	if !VerifyWithPublicKey(schedulerKey, message, sign) {
+		return fmt.Errorf("%w : node [%s]", ErrBadDownloadInfoSign, di.URL)
+	}
+	return nil
+}
+
+// verifyBlockData re-hash the data with the hash function of the cid prefix
+// and compare it with the cid, every hash function registered
+// in go-multihash is supported, eg: sha2-256, blake2b, blake3, sha3
+func verifyBlockData(c cid.Cid, data []byte) error {
+	chk, err := c.Prefix().Sum(data)
+	if err != nil {
+		return err
+	}
+	if !chk.Equals(c) {
+		return fmt.Errorf("%w : expected [%s], got [%s]", ErrHashMismatch, c.String(), chk.String())
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package util
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/ipfs/go-cid"
+	"github.com/linguohua/titan/api"
+	mh "github.com/multiformats/go-multihash"
+	"testing"
+)
+
+func TestVerifyBlockData(t *testing.T) {
+	block := []byte("hello titan !!!")
+	for _, code := range []uint64{mh.SHA2_256, mh.SHA2_512, mh.BLAKE3, mh.BLAKE2B_MIN + 31, mh.SHA3_256} {
+		prefix := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: code, MhLength: -1}
+		c, err := prefix.Sum(block)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err = verifyBlockData(c, block); err != nil {
+			t.Errorf("hash function [%s] : %s", mh.Codes[code], err.Error())
+			return
+		}
+		err = verifyBlockData(c, []byte("bad data"))
+		if !errors.Is(err, ErrHashMismatch) {
+			t.Errorf("hash function [%s] : expected hash mismatch, got %v", mh.Codes[code], err)
+			return
+		}
+	}
+}
+
+// testSignature a layout of the test scheduler, not the one of titan
+func testSignature(di *api.DownloadInfoResult) ([]byte, []byte, error) {
+	sign, err := hex.DecodeString(di.Sign)
+	if err != nil {
+		return nil, nil, err
+	}
+	return []byte(fmt.Sprintf("%d\n%d\n%d\n%s", di.SN, di.SignTime, di.TimeOut, di.URL)), sign, nil
+}
+
+func TestVerifyDownloadInfo(t *testing.T) {
+	scheduler, err := NewEd25519Signer()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	di := &api.DownloadInfoResult{URL: "http://127.0.0.1:3000/block/get", SN: 1, SignTime: 1668000000, TimeOut: 60}
+	message, _, _ := testSignature(di)
+	sign, err := scheduler.Sign(message)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	di.Sign = hex.EncodeToString(sign)
+	schedulerKey := scheduler.PublicKey()
+	if err = verifyDownloadInfo(schedulerKey, di, testSignature); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for name, forged := range map[string]api.DownloadInfoResult{
+		// redirected to another node
+		"url":     {URL: "http://127.0.0.2:3000/block/get", SN: 1, SignTime: 1668000000, TimeOut: 60},
+		"sn":      {URL: "http://127.0.0.1:3000/block/get", SN: 2, SignTime: 1668000000, TimeOut: 60},
+		"timeout": {URL: "http://127.0.0.1:3000/block/get", SN: 1, SignTime: 1668000000, TimeOut: 600},
+	} {
+		forged.Sign = di.Sign
+		if err = verifyDownloadInfo(schedulerKey, &forged, testSignature); !errors.Is(err, ErrBadDownloadInfoSign) {
+			t.Errorf("%s: expected bad sign, got %v", name, err)
+		}
+	}
+
+	bad := *di
+	bad.Sign = "not hex"
+	if err = verifyDownloadInfo(schedulerKey, &bad, testSignature); !errors.Is(err, ErrBadDownloadInfoSign) {
+		t.Errorf("expected bad sign for an undecodable sign, got %v", err)
+	}
+	// there is no default layout
+	if err = verifyDownloadInfo(schedulerKey, di, nil); !errors.Is(err, ErrBadDownloadInfoSign) {
+		t.Errorf("expected bad sign without DownloadInfoSignature, got %v", err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"math"
 	"math/rand"
 	"sort"
+	"sync"
 	"time"
 )
 
@@ -20,49 +21,144 @@ var (
 	// If there are no Choices available to the Chooser with a weight >= 1,
 	// there are no valid choices and Pick would produce a runtime panic.
 	errNoValidChoices = errors.New("zero Choices with Weight >= 1")
+	// Update or Remove of a node that the Chooser does not know
+	errChoiceNotFound = errors.New("choice not found")
 )
 
 // A Chooser caches many possible Choices in a structure designed to improve
 // performance on repeated calls for weighted random selection.
+// A Chooser is safe for concurrent use.
 type Chooser struct {
-	data   []*api.DownloadInfoResult
-	totals []int
-	max    int
+	mu  sync.Mutex
+	rnd *rand.Rand
+	// the caller's slice is copied, weights are kept apart,
+	// so the caller's data is never changed
+	data    []*api.DownloadInfoResult
+	weights []int
+	totals  []int
+	max     int
 }
 
 // NewChooser initializes a new Chooser for picking from the provided choices.
+// the source of randomness is seeded with the current time.
 func NewChooser(choices ...*api.DownloadInfoResult) (*Chooser, error) {
-	sort.Slice(choices, func(i, j int) bool {
-		return choices[i].Weight < choices[j].Weight
-	})
-
-	totals := make([]int, len(choices))
-	runningTotal := 0
-	for i, c := range choices {
-		weight := c.Weight
-		if weight < 0 {
-			continue // ignore negative weights, can never be picked
-		}
+	return NewChooserWithSource(rand.NewSource(time.Now().UnixNano()), choices...)
+}
 
-		if (math.MaxInt64 - runningTotal) <= weight {
-			return nil, errWeightOverflow
-		}
-		runningTotal += weight
-		totals[i] = runningTotal
+// NewChooserWithSource initializes a new Chooser with a private source of randomness,
+// eg: rand.NewSource(1) for reproducible tests.
+func NewChooserWithSource(src rand.Source, choices ...*api.DownloadInfoResult) (*Chooser, error) {
+	weights := make([]int, len(choices))
+	for i, v := range choices {
+		weights[i] = v.Weight
 	}
+	return newChooser(src, choices, weights)
+}
 
-	if runningTotal < 1 {
+// newChooser the weights of the choices are given apart, eg: scaled
+func newChooser(src rand.Source, choices []*api.DownloadInfoResult, weights []int) (*Chooser, error) {
+	c := &Chooser{
+		rnd:     rand.New(src),
+		data:    make([]*api.DownloadInfoResult, len(choices)),
+		weights: weights,
+	}
+	copy(c.data, choices)
+	if err := c.rebuild(); err != nil {
+		return nil, err
+	}
+	if c.max < 1 {
 		return nil, errNoValidChoices
 	}
+	return c, nil
+}
 
-	return &Chooser{data: choices, totals: totals, max: runningTotal}, nil
+// rebuild the running totals after a change of weights
+func (c *Chooser) rebuild() error {
+	totals := make([]int, len(c.weights))
+	runningTotal := 0
+	for i, weight := range c.weights {
+		// ignore negative weights, can never be picked
+		if weight > 0 {
+			if (math.MaxInt64 - runningTotal) <= weight {
+				return errWeightOverflow
+			}
+			runningTotal += weight
+		}
+		totals[i] = runningTotal
+	}
+	c.totals = totals
+	c.max = runningTotal
+	return nil
 }
 
 // Pick returns a single weighted random api.DownloadInfoResult from the Chooser.
-// Utilizes global rand as the source of randomness.
+// nil if all choices were removed or down-weighted to zero.
 func (c *Chooser) Pick() *api.DownloadInfoResult {
-	rand.Seed(time.Now().UnixNano())
-	r := rand.Intn(c.max) + 1
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.max < 1 {
+		return nil
+	}
+	r := c.rnd.Intn(c.max) + 1
 	i := sort.SearchInts(c.totals, r)
 	return c.data[i]
 }
+
+// Update change the weight of the node with url, eg: down-weight a failing node,
+// weight <= 0 means the node is never picked.
+func (c *Chooser) Update(url string, weight int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := c.index(url)
+	if i < 0 {
+		return errChoiceNotFound
+	}
+	old := c.weights[i]
+	c.weights[i] = weight
+	if err := c.rebuild(); err != nil {
+		c.weights[i] = old
+		_ = c.rebuild()
+		return err
+	}
+	return nil
+}
+
+// Remove the node with url from the choices.
+func (c *Chooser) Remove(url string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := c.index(url)
+	if i < 0 {
+		return errChoiceNotFound
+	}
+	c.data = append(c.data[:i:i], c.data[i+1:]...)
+	c.weights = append(c.weights[:i:i], c.weights[i+1:]...)
+	return c.rebuild()
+}
+
+// Weight returns the current weight of the node with url.
+func (c *Chooser) Weight(url string) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := c.index(url)
+	if i < 0 {
+		return 0, false
+	}
+	return c.weights[i], true
+}
+
+// size the number of choices
+func (c *Chooser) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.data)
+}
+
+func (c *Chooser) index(url string) int {
+	for i, v := range c.data {
+		if v.URL == url {
+			return i
+		}
+	}
+	return -1
+}
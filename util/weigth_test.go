@@ -2,6 +2,8 @@ package util
 
 import (
 	"github.com/linguohua/titan/api"
+	"math/rand"
+	"sync"
 	"testing"
 )
 
@@ -43,3 +45,65 @@ func TestRandomDraw(t *testing.T) {
 		t.Logf("%s %s%d,%s%d", k.URL, "weigth:", k.Weight, "total times:", v)
 	}
 }
+
+func TestChooserSeeded(t *testing.T) {
+	pool := []*api.DownloadInfoResult{testData[3], testData[0], testData[2], testData[1]}
+	first, err := NewChooserWithSource(rand.NewSource(1), pool...)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	second, err := NewChooserWithSource(rand.NewSource(1), pool...)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i := 0; i < 100; i++ {
+		if first.Pick() != second.Pick() {
+			t.Error("same seed must pick the same sequence")
+			return
+		}
+	}
+	if pool[0] != testData[3] || pool[1] != testData[0] {
+		t.Error("NewChooser must not reorder the input")
+	}
+}
+
+func TestChooserUpdateRemove(t *testing.T) {
+	ch, err := NewChooserWithSource(rand.NewSource(1), testData...)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err = ch.Update("four", 0); err != nil {
+		t.Error(err)
+		return
+	}
+	if err = ch.Remove("three"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				item := ch.Pick()
+				if item.URL == "four" || item.URL == "three" {
+					t.Errorf("picked %s", item.URL)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if testData[3].Weight != 4 {
+		t.Error("Update must not change the input")
+	}
+	if err = ch.Update("unknown", 1); err == nil {
+		t.Error("expected error for unknown choice")
+	}
+}